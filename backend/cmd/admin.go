@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+)
+
+func (app *application) requireAdmin(c *gin.Context) {
+	if app.config.App.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.App.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+func (app *application) replayDLQ(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	jobs, err := broker.ReplayDLQ(c.Request.Context(), app.broker, limit, time.Duration(app.config.Kafka.DLQReplayWait)*time.Second)
+	for _, job := range jobs {
+		if job.JobID == uuid.Nil {
+			continue
+		}
+		if err := app.models.Jobs.SetStatus(job.JobID, database.JobQueued); err != nil {
+			log.Printf("Job %s: failed to requeue: %v", job.JobID, err)
+		}
+	}
+	if err != nil {
+		log.Printf("DLQ replay: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay DLQ", "replayed": len(jobs), "jobs": jobs})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replayed": len(jobs), "jobs": jobs})
+}
@@ -1,223 +1,480 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/ksamf/video-upscaling/backend/internal/database"
-	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
-	"github.com/ksamf/video-upscaling/backend/internal/rest"
-)
-
-func (app *application) uploadVideo(c *gin.Context) {
-	upscale, _ := strconv.ParseBool(c.DefaultQuery("up", "false"))
-	realisticVideo, _ := strconv.ParseBool(c.DefaultQuery("real", "true"))
-
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error get file"})
-		return
-	}
-	defer file.Close()
-
-	ext := filepath.Ext(header.Filename)
-	name := c.DefaultQuery("name", strings.Trim(header.Filename, "."+ext))
-	allowed := map[string]bool{
-		".mp4": true, ".mov": true, ".avi": true, ".mkv": true, ".webm": true,
-	}
-	if !allowed[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Недопустимый формат файла"})
-		return
-	}
-
-	videoId := uuid.New()
-	tmpInputPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s_input%s", videoId, ext))
-
-	out, err := os.Create(tmpInputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tmp file"})
-		return
-	}
-
-	if _, err := io.Copy(out, file); err != nil {
-		out.Close()
-		os.Remove(tmpInputPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tmp file"})
-		return
-	}
-	out.Close()
-
-	s3Key := fmt.Sprintf("%s/tmp%s", videoId, ext)
-
-	tmpFile, err := os.Open(tmpInputPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open tmp file"})
-		return
-	}
-	defer tmpFile.Close()
-
-	if err := app.s3.PutObject(s3Key, tmpFile); err != nil {
-		os.Remove(tmpInputPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload to S3"})
-		return
-	}
-	os.Remove(tmpInputPath)
-
-	msg := broker.VideoJob{
-		VideoID:        videoId,
-		FileName:       name,
-		FileExt:        ext,
-		Upscale:        upscale,
-		RealisticVideo: realisticVideo,
-		BaseURL:        app.config.Api.BaseURL,
-	}
-
-	if err := broker.Publish(context.Background(), app.kafka.Writer, msg); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish kafka"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Видео успешно загружено",
-	})
-}
-
-func (app *application) getVideo(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
-	}
-	videoCache, err := app.redis.Get(c, id.String()).Result()
-	if err == nil {
-		c.JSON(http.StatusOK, videoCache)
-		return
-	}
-	video, err := app.models.Videos.GetByID(id, app.s3.GetURL)
-	if video == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
-		return
-	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video"})
-	}
-	app.redis.Set(c, id.String(), video, time.Minute*10)
-	c.JSON(http.StatusOK, video)
-}
-
-func (app *application) getAllVideos(c *gin.Context) {
-	limit := c.Query("limit")
-	offset := c.Query("offset")
-	videosCache, err := app.redis.Get(c, "videos_"+limit+"_"+offset).Result()
-	if err == nil {
-		c.JSON(http.StatusOK, videosCache)
-		return
-	}
-	videos, err := app.models.Videos.GetAll(limit, offset, app.s3.GetURL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get videos: %w", err)})
-		return
-	}
-	app.redis.Set(c, "videos_"+limit+"_"+offset, videos, time.Minute*10)
-	c.JSON(http.StatusOK, videos)
-}
-func (app *application) deleteVideo(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
-		return
-	}
-	err = app.models.Videos.Delete(id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video from database"})
-		return
-	}
-	err = app.s3.DeleteObject(id.String())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video from S3"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "Video deleted successfully"})
-}
-func (app *application) updateVideoPartial(c *gin.Context) {
-	id, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
-		return
-	}
-	var updateVideo database.Video
-	if err := c.BindJSON(&updateVideo); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	updateVideo.VideoId = id
-	if updateVideo.LanguageId != 0 {
-		err = app.models.Videos.UpdatePartial(id, "language_id", updateVideo.LanguageId)
-	}
-	if updateVideo.Quality != 0 {
-		err = app.models.Videos.UpdatePartial(id, "qualities_id", updateVideo.Quality)
-	}
-	if updateVideo.Name != "" {
-		err = app.models.Videos.UpdatePartial(id, "name", updateVideo.Name)
-	}
-	if updateVideo.VideoPath != "" {
-		err = app.models.Videos.UpdatePartial(id, "video_path", updateVideo.VideoPath)
-	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update video"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "Video updated successfully"})
-}
-
-func (app *application) getVideoSubtitles(c *gin.Context) {
-	id := c.Param("id")
-	lang := c.DefaultQuery("lang", "en")
-	subCache, err := app.redis.Get(c, id+"_"+lang+"_sub").Result()
-	if err == nil {
-		c.JSON(http.StatusOK, subCache)
-		return
-	}
-	if app.s3.ExitsObjects(id + "/" + lang + "_sub.vtt") {
-		c.String(http.StatusOK, fmt.Sprintf("https://%s/%s/%s/%s_sub.vtt", app.s3.Endpoint, app.s3.BucketName, id, lang))
-	} else {
-		err := rest.TranslateSubtitles(uuid.MustParse(id), app.config.Api.BaseURL, lang)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
-			return
-		}
-		subPath := fmt.Sprintf("https://%s/%s/%s/%s_sub.vtt", app.s3.Endpoint, app.s3.BucketName, id, lang)
-		app.redis.Set(c, id+"_"+lang+"_sub", subPath, time.Minute*30)
-		c.JSON(http.StatusOK, gin.H{"message": subPath})
-	}
-}
-
-// func (app *application) getVideoDubbing(c *gin.Context) {
-// 	id := c.Param("id")
-// 	lang := c.DefaultQuery("lang", "en")
-// 	if app.s3.ExitsObjects(id + "/" + lang + "_dub.mp3") {
-// 		c.String(http.StatusOK, fmt.Sprintf("https://%s/%s/%s/%s_dub.mp3", app.s3.Endpoint, app.s3.BucketName, id, lang))
-// 	} else {
-// 		if !app.s3.ExitsObjects(id + "/" + lang + "_sub.vtt") {
-// 			err := rest.TranslateSubtitles(uuid.MustParse(id), app.config.Api.BaseURL, lang)
-// 			if err != nil {
-// 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
-// 				return
-// 			}
-// 		}
-// 		err := rest.CreateDubbing(uuid.MustParse(id), app.config.Api.BaseURL, lang)
-// 		if err != nil {
-// 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
-// 			return
-// 		}
-// 		c.String(http.StatusOK, fmt.Sprintf("https://%s/%s/%s/%s_dub.mp3", app.s3.Endpoint, app.s3.BucketName, id, lang))
-// 	}
-// }
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/rest"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+)
+
+func (app *application) uploadVideo(c *gin.Context) {
+	upscale, _ := strconv.ParseBool(c.DefaultQuery("up", "false"))
+	realisticVideo, _ := strconv.ParseBool(c.DefaultQuery("real", "true"))
+	priority, err := broker.ParsePriority(c.Query("priority"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	visibility, err := database.ParseVisibility(c.Query("visibility"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error get file"})
+		return
+	}
+	defer file.Close()
+
+	name := c.DefaultQuery("name", strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)))
+
+	videoId := uuid.New()
+	tmpInputPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s_input%s", videoId, safeExt(header.Filename)))
+
+	out, err := os.Create(tmpInputPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tmp file"})
+		return
+	}
+
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		os.Remove(tmpInputPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tmp file"})
+		return
+	}
+	out.Close()
+
+	container, err := app.validateFile(c.Request.Context(), tmpInputPath)
+	if err != nil {
+		os.Remove(tmpInputPath)
+		respondValidationError(c, err)
+		return
+	}
+	ext := container.Ext
+
+	s3Key := fmt.Sprintf("%s/tmp%s", videoId, ext)
+
+	tmpFile, err := os.Open(tmpInputPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open tmp file"})
+		return
+	}
+	defer tmpFile.Close()
+
+	sum, err := storage.Checksum(tmpFile)
+	if err != nil {
+		os.Remove(tmpInputPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tmp file"})
+		return
+	}
+	opts := storage.VideoObject(videoId.String(), "").WithChecksum(sum)
+	if err := app.s3.Put(c, s3Key, tmpFile, header.Size, opts); err != nil {
+		os.Remove(tmpInputPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload to S3"})
+		return
+	}
+	os.Remove(tmpInputPath)
+
+	jobId, err := app.enqueueVideoJob(videoId, name, ext, upscale, realisticVideo, priority, visibility)
+	if err != nil {
+		log.Printf("Video %s: %v", videoId, err)
+		_ = app.s3.Delete(c, s3Key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue video"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Видео успешно загружено",
+		"video_id": videoId,
+		"job_id":   jobId,
+	})
+}
+
+func (app *application) enqueueVideoJob(videoId uuid.UUID, name, ext string, upscale, realisticVideo bool, priority, visibility string) (uuid.UUID, error) {
+	return app.publishVideoJob(broker.VideoJob{
+		VideoID:        videoId,
+		FileName:       name,
+		FileExt:        ext,
+		Upscale:        upscale,
+		RealisticVideo: realisticVideo,
+		Priority:       priority,
+	}, &database.Video{VideoId: videoId, Name: name, Visibility: visibility})
+}
+
+// publishVideoJob records a new job for msg, and video if it is new, and
+// leaves the message in the outbox for the relay to send to the workers. Once
+// it returns the job is certain to reach them, even if the broker is down.
+func (app *application) publishVideoJob(msg broker.VideoJob, video *database.Video) (uuid.UUID, error) {
+	msg.JobID = uuid.New()
+	msg.BaseURL = app.config.Api.BaseURL
+	out, err := broker.JobMessage(msg)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	msgs := []*database.OutboxMessage{utils.OutboxMessage(out)}
+	if video != nil {
+		uploaded, err := uploadedEvent(msg)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		msgs = append(msgs, utils.OutboxMessage(uploaded))
+	}
+	job := &database.Job{JobId: msg.JobID, VideoId: msg.VideoID}
+	if err := app.models.Jobs.Enqueue(video, job, msgs...); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	app.outbox.Notify()
+	return msg.JobID, nil
+}
+
+func uploadedEvent(job broker.VideoJob) (broker.Message, error) {
+	e, err := events.New(events.TypeVideoUploaded, job.VideoID, events.VideoUploaded{
+		Name:     job.FileName,
+		JobID:    job.JobID,
+		Priority: job.Priority,
+		Upscale:  job.Upscale,
+	})
+	if err != nil {
+		return broker.Message{}, err
+	}
+	return broker.EventMessage(e)
+}
+
+func (app *application) getVideo(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+	}
+	videoCache, err := app.redis.Get(c, cache.VideoKey(id)).Result()
+	if err == nil {
+		c.JSON(http.StatusOK, videoCache)
+		return
+	}
+	video, err := app.models.Videos.GetByID(id, app.videoURL)
+	if video == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video"})
+	}
+	video.Metadata, err = app.models.Metadata.GetByVideoID(id)
+	if err != nil {
+		log.Printf("Video %s: failed to get metadata: %v", id, err)
+	}
+	if err := app.playbackURLs(c, video); err != nil {
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video"})
+		return
+	}
+	app.redis.Set(c, cache.VideoKey(id), video, app.cacheTTL(time.Minute*10))
+	c.JSON(http.StatusOK, video)
+}
+
+func (app *application) getAllVideos(c *gin.Context) {
+	limit := c.Query("limit")
+	offset := c.Query("offset")
+	videosCache, err := app.redis.Get(c, cache.VideoListKey(limit, offset)).Result()
+	if err == nil {
+		c.JSON(http.StatusOK, videosCache)
+		return
+	}
+	videos, err := app.models.Videos.GetAll(limit, offset, app.videoURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get videos: %v", err)})
+		return
+	}
+	app.redis.Set(c, cache.VideoListKey(limit, offset), videos, time.Minute*10)
+	c.JSON(http.StatusOK, videos)
+}
+
+// deleteVideo answers 202 when the deletion failed part-way; it is retried in
+// the background until it completes.
+func (app *application) deleteVideo(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	deletion, err := app.deleter.Delete(c, id)
+	if err != nil {
+		log.Printf("Video %s: %v", id, err)
+		if deletion == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Video deletion will be retried", "step": deletion.Step})
+		return
+	}
+	if deletion == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Video deleted successfully"})
+}
+func (app *application) updateVideoPartial(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	var updateVideo database.Video
+	if err := c.BindJSON(&updateVideo); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updateVideo.VideoId = id
+	switch updateVideo.Visibility {
+	case "", database.VisibilityPublic, database.VisibilityPrivate:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Visibility must be public or private"})
+		return
+	}
+	if updateVideo.LanguageId != 0 {
+		err = app.models.Videos.UpdatePartial(id, "language_id", updateVideo.LanguageId)
+	}
+	if updateVideo.Quality != 0 {
+		err = app.models.Videos.UpdatePartial(id, "qualities_id", updateVideo.Quality)
+	}
+	if updateVideo.Name != "" {
+		err = app.models.Videos.UpdatePartial(id, "name", updateVideo.Name)
+	}
+	if updateVideo.VideoPath != "" {
+		err = app.models.Videos.UpdatePartial(id, "video_path", updateVideo.VideoPath)
+	}
+	if updateVideo.Visibility != "" {
+		err = app.models.Videos.UpdatePartial(id, "visibility", updateVideo.Visibility)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update video"})
+		return
+	}
+	// Cached responses may hold URLs that no longer work.
+	if err := cache.InvalidateVideo(c, app.redis, id); err != nil {
+		log.Printf("Video %s: %v", id, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Video updated successfully"})
+}
+
+func (app *application) getJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	job, err := app.models.Jobs.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job"})
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (app *application) cancelJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	job, err := app.models.Jobs.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job"})
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if database.Finished(job.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is already finished", "status": job.Status})
+		return
+	}
+	if err := app.requestCancel(c, job.JobId); err != nil {
+		log.Printf("Job %s: %v", job.JobId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job cancellation requested", "job_id": job.JobId})
+}
+
+func (app *application) requestCancel(ctx context.Context, jobId uuid.UUID) error {
+	return utils.CancelJob(ctx, app.models, app.redis, jobId)
+}
+
+func (app *application) getVideoJobs(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	jobs, err := app.models.Jobs.GetByVideoID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (app *application) getVideoProgress(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	key := cache.ProgressKey(id)
+
+	pubsub := app.redis.Subscribe(ctx, key)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to progress"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if snapshot, err := app.redis.Get(ctx, key).Result(); err == nil {
+		c.SSEvent("progress", snapshot)
+		c.Writer.Flush()
+		if progressFinished(snapshot) {
+			return
+		}
+	}
+
+	messages := pubsub.Channel()
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+			c.SSEvent("progress", msg.Payload)
+			return !progressFinished(msg.Payload)
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-ctx.Done():
+			return false
+		case <-app.stopping:
+			return false
+		}
+	})
+}
+
+func progressFinished(payload string) bool {
+	var p cache.Progress
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return false
+	}
+	return database.Finished(p.Status)
+}
+
+func (app *application) getVideoSubtitles(c *gin.Context) {
+	id := c.Param("id")
+	lang := c.DefaultQuery("lang", "en")
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	subCache, err := app.redis.Get(c, cache.SubtitlesKey(id, lang)).Result()
+	if err == nil {
+		c.JSON(http.StatusOK, subCache)
+		return
+	}
+	visibility, err := app.models.Videos.Visibility(videoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subtitles"})
+		return
+	}
+	if visibility == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	exists, err := storage.Exists(c, app.s3, utils.SubtitlesKey(id, lang))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subtitles"})
+		return
+	}
+	if exists {
+		subPath, err := app.objectURL(c, utils.SubtitlesKey(id, lang))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subtitles"})
+			return
+		}
+		c.String(http.StatusOK, subPath)
+	} else {
+		err := rest.TranslateSubtitles(videoID, app.config.Api.BaseURL, lang)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
+			return
+		}
+		err = app.outbox.Emit(events.TypeSubtitlesReady, videoID, events.SubtitlesReady{
+			Language:   lang,
+			Key:        utils.SubtitlesKey(id, lang),
+			Translated: true,
+		})
+		if err != nil {
+			log.Printf("Video %s: %v", id, err)
+		}
+		subPath, err := app.objectURL(c, utils.SubtitlesKey(id, lang))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subtitles"})
+			return
+		}
+		app.redis.Set(c, cache.SubtitlesKey(id, lang), subPath, app.cacheTTL(time.Minute*30))
+		c.JSON(http.StatusOK, gin.H{"message": subPath})
+	}
+}
+
+// func (app *application) getVideoDubbing(c *gin.Context) {
+// 	id := c.Param("id")
+// 	lang := c.DefaultQuery("lang", "en")
+// 	if app.s3.ExitsObjects(id + "/" + lang + "_dub.mp3") {
+// 		c.String(http.StatusOK, fmt.Sprintf("https://%s/%s/%s/%s_dub.mp3", app.s3.Endpoint, app.s3.BucketName, id, lang))
+// 	} else {
+// 		if !app.s3.ExitsObjects(id + "/" + lang + "_sub.vtt") {
+// 			err := rest.TranslateSubtitles(uuid.MustParse(id), app.config.Api.BaseURL, lang)
+// 			if err != nil {
+// 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
+// 				return
+// 			}
+// 		}
+// 		err := rest.CreateDubbing(uuid.MustParse(id), app.config.Api.BaseURL, lang)
+// 		if err != nil {
+// 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate subtitles"})
+// 			return
+// 		}
+// 		c.String(http.StatusOK, fmt.Sprintf("https://%s/%s/%s/%s_dub.mp3", app.s3.Endpoint, app.s3.BucketName, id, lang))
+// 	}
+// }
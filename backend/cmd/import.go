@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/importer"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+type importRequest struct {
+	URL            string `json:"url" binding:"required"`
+	Name           string `json:"name"`
+	Upscale        bool   `json:"up"`
+	RealisticVideo *bool  `json:"real"`
+	Priority       string `json:"priority"`
+	Visibility     string `json:"visibility"`
+}
+
+func (app *application) importVideo(c *gin.Context) {
+	var req importRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priority, err := broker.ParsePriority(req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	visibility, err := database.ParseVisibility(req.Visibility)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	remote, err := app.importer.Open(c.Request.Context(), req.URL)
+	if err != nil {
+		log.Printf("Import %s: %v", req.URL, err)
+		c.JSON(importStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer remote.Body.Close()
+
+	if req.Name == "" {
+		req.Name = remote.Name
+	}
+	videoId := uuid.New()
+	s3Key := fmt.Sprintf("%s/tmp%s", videoId, remote.Ext)
+	if err := app.s3.Put(c, s3Key, remote.Body, remote.Size, storage.VideoObject(videoId.String(), "")); err != nil {
+		log.Printf("Import %s: %v", req.URL, err)
+		_ = app.s3.Delete(c, s3Key)
+		if remote.Body.Exceeded() {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": importer.ErrTooLarge.Error()})
+			return
+		}
+		if err := remote.Body.Interrupted(); err != nil {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to download remote video"})
+		return
+	}
+
+	if _, err := app.validateObject(c, s3Key); err != nil {
+		log.Printf("Import %s: %v", req.URL, err)
+		// Nothing refers to the object yet. A video that couldn't be checked
+		// answers 500 rather than 422, so the client imports it again.
+		_ = app.s3.Delete(c, s3Key)
+		respondValidationError(c, err)
+		return
+	}
+
+	realisticVideo := req.RealisticVideo == nil || *req.RealisticVideo
+	jobId, err := app.enqueueVideoJob(videoId, req.Name, remote.Ext, req.Upscale, realisticVideo, priority, visibility)
+	if err != nil {
+		log.Printf("Video %s: %v", videoId, err)
+		_ = app.s3.Delete(c, s3Key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue video"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Видео успешно загружено",
+		"video_id": videoId,
+		"job_id":   jobId,
+	})
+}
+
+func importStatus(err error) int {
+	switch {
+	case errors.Is(err, importer.ErrInvalidURL), errors.Is(err, importer.ErrForbiddenAddress):
+		return http.StatusBadRequest
+	case errors.Is(err, importer.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, importer.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, importer.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -1,64 +1,125 @@
-package main
-
-import (
-	"log"
-
-	_ "github.com/lib/pq"
-
-	"github.com/ksamf/video-upscaling/backend/internal/config"
-	"github.com/ksamf/video-upscaling/backend/internal/database"
-	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
-	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
-	"github.com/ksamf/video-upscaling/backend/internal/storage"
-	"github.com/ksamf/video-upscaling/backend/internal/utils"
-	"github.com/redis/go-redis/v9"
-)
-
-type application struct {
-	host   string
-	port   int
-	models database.Models
-	config *config.Config
-	s3     *storage.Storage
-	redis  *redis.Client
-	kafka  *broker.KafkaClients
-}
-
-func main() {
-
-	conf := config.New()
-	s3 := storage.New(conf)
-	pool := database.New(conf)
-	redis := cache.New(conf)
-	kafka := broker.New(conf)
-
-	defer pool.Close()
-	defer redis.Close()
-	defer s3.CredContext().Client.CloseIdleConnections()
-	defer func() {
-		if kafka.Writer != nil {
-			kafka.Writer.Close()
-		}
-		if kafka.Reader != nil {
-			kafka.Reader.Close()
-		}
-	}()
-	models := database.NewModel(pool)
-	buckets := storage.NewBucket(s3, conf)
-
-	app := &application{
-		host:   conf.App.Host,
-		port:   conf.App.Port,
-		models: models,
-		config: conf,
-		s3:     buckets,
-		redis:  redis,
-		kafka:  kafka,
-	}
-	log.Println("Kafka worker started and waiting for messages...")
-	go utils.StartVideoWorker(app.kafka.Reader, app.models.Videos, app.s3)
-	if err := app.serve(); err != nil {
-		panic(err)
-	}
-
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/importer"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/tus"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+type application struct {
+	host     string
+	port     int
+	models   database.Models
+	config   *config.Config
+	s3       storage.Store
+	redis    *redis.Client
+	broker   broker.Broker
+	outbox   *utils.OutboxRelay
+	deleter  *utils.Deleter
+	uploads  *tus.Store
+	importer *importer.Importer
+	signer   *storage.Signer
+	stopping chan struct{}
+}
+
+const usage = `Usage: backend <command> [flags]
+
+Commands:
+  server     serve the HTTP API (default)
+  worker     process video jobs from Kafka
+  migrate    apply or roll back database migrations
+  reprocess  queue existing videos to be processed again
+
+Run "backend <command> -h" to see the flags of a command.
+`
+
+// Each command sets up only what it uses, so API replicas and workers can be
+// scaled separately from the same image.
+var commands = map[string]func(args []string) error{
+	"server":    runServer,
+	"worker":    runWorker,
+	"migrate":   runMigrate,
+	"reprocess": runReprocess,
+}
+
+func main() {
+	name, args := "server", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+}
+
+// signalContext is cancelled on SIGINT or SIGTERM. A second signal kills the
+// process instead of waiting for the drain.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// sharedBackends rejects the in-memory broker and storage for commands that
+// have to share them with another process.
+func sharedBackends(conf *config.Config, command string) error {
+	if conf.Kafka.Driver == broker.DriverMemory {
+		return fmt.Errorf("%s can't use the memory broker, it only works within one process", command)
+	}
+	if conf.Storage.Driver == storage.DriverMemory {
+		return fmt.Errorf("%s can't use the memory storage, it only works within one process", command)
+	}
+	return nil
+}
+
+// videoURL is the address under which the API serves the files of a video.
+func (app *application) videoURL(id uuid.UUID) string {
+	return fmt.Sprintf("%s/video/%s", strings.TrimSuffix(app.config.App.PublicURL, "/"), id)
+}
+
+func outboxRelay(conf *config.Config, models database.Models, publisher broker.Publisher) *utils.OutboxRelay {
+	return utils.NewOutboxRelay(models.Outbox, publisher, time.Duration(conf.Kafka.OutboxInterval)*time.Second)
+}
+
+func workerOptions(conf *config.Config) utils.WorkerOptions {
+	return utils.WorkerOptions{
+		JobRetry: retry.Policy{
+			Attempts:  conf.Worker.MaxAttempts,
+			BaseDelay: time.Duration(conf.Worker.RetryBaseDelay) * time.Second,
+			MaxDelay:  time.Duration(conf.Worker.RetryMaxDelay) * time.Second,
+		},
+		Grace:          time.Duration(conf.Worker.GracePeriod) * time.Second,
+		MaxJobs:        conf.Worker.MaxJobs,
+		FFmpegCapacity: conf.Worker.FFmpegCapacity,
+	}
+}
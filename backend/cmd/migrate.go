@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+)
+
+const migrateUsage = `Usage: backend migrate [flags] <action>
+
+Actions:
+  up [n]           apply all or the next n pending migrations
+  down [n|all]     roll back the last n migrations (default 1) or all of them
+  version          print the current version
+  force <version>  record version as applied without running anything, -1 for none
+
+Flags:
+`
+
+func runMigrate(args []string) error {
+	conf := config.New()
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := flags.String("path", "migrations", "directory with the migration files")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), migrateUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	action, params := flags.Arg(0), flags.Args()[1:]
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	migrator, err := database.NewMigrator(conf, *dir)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	migrator.Log = migrateLogger{}
+	go func() {
+		<-ctx.Done()
+		// Stops after the migration that is running.
+		migrator.GracefulStop <- true
+	}()
+
+	switch action {
+	case "up":
+		steps, err := migrationSteps(params, 0)
+		if err != nil {
+			return err
+		}
+		if steps == 0 {
+			err = migrator.Up()
+		} else {
+			err = migrator.Steps(steps)
+		}
+		return migrationResult(migrator, err)
+	case "down":
+		steps, err := migrationSteps(params, 1)
+		if err != nil {
+			return err
+		}
+		if steps == 0 {
+			err = migrator.Down()
+		} else {
+			err = migrator.Steps(-steps)
+		}
+		return migrationResult(migrator, err)
+	case "version":
+		version, dirty, err := migrator.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			fmt.Println("no migrations applied")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+			return nil
+		}
+		fmt.Println(version)
+		return nil
+	case "force":
+		if len(params) != 1 {
+			return errors.New("force needs exactly one version")
+		}
+		version, err := strconv.Atoi(params[0])
+		if err != nil || version < -1 {
+			return fmt.Errorf("invalid version %q", params[0])
+		}
+		return migrator.Force(version)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// migrationResult reports where up or down left the database.
+func migrationResult(migrator *migrate.Migrate, err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Println("No pending migrations")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	version, _, err := migrator.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		log.Println("Rolled back every migration")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated to version %d", version)
+	return nil
+}
+
+// migrateLogger prints every migration that is applied or rolled back.
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...any) {
+	log.Printf(format, v...)
+}
+
+func (migrateLogger) Verbose() bool {
+	return true
+}
+
+// migrationSteps reads the optional step count of up and down, returning 0
+// for "all".
+func migrationSteps(params []string, fallback int) (int, error) {
+	switch {
+	case len(params) == 0:
+		return fallback, nil
+	case len(params) > 1:
+		return 0, errors.New("too many arguments")
+	case params[0] == "all":
+		return 0, nil
+	}
+	steps, err := strconv.Atoi(params[0])
+	if err != nil || steps < 1 {
+		return 0, fmt.Errorf("invalid number of steps %q", params[0])
+	}
+	return steps, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/manifest"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+)
+
+// Every object is handed out as a presigned URL, whatever the visibility of
+// its video, so the bucket stays private and nothing can be read at its key
+// without one. A player resolves the URIs in a manifest against the
+// manifest's own URL, so the manifests are served here instead, with every
+// segment URI presigned.
+//
+// The manifests and the stream of a private video are only served with the
+// token its playback URLs are signed with, which the video's details hand
+// out.
+
+// playbackSigner returns the signer of the playback URLs: the one of the
+// signing key if there is one, else the local or memory store's own, else a
+// random one that only lasts as long as the process.
+func playbackSigner(conf *config.Config, store storage.Store) *storage.Signer {
+	if conf.App.SigningKey != "" {
+		return storage.NewSigner([]byte(conf.App.SigningKey))
+	}
+	if files, ok := store.(interface{ Signer() *storage.Signer }); ok {
+		return files.Signer()
+	}
+	log.Println("APP_SIGNING_KEY is not set, playback URLs of private videos only work until the API restarts")
+	return storage.NewSigner([]byte(rand.Text()))
+}
+
+// playbackScope is what the playback URLs of a video are signed for.
+func playbackScope(id uuid.UUID) string {
+	return "video/" + id.String()
+}
+
+// authorizePlayback answers the request itself unless the video is public or
+// the request carries a valid playback token for it.
+func (app *application) authorizePlayback(c *gin.Context, id uuid.UUID, visibility string) bool {
+	if visibility != database.VisibilityPrivate || app.signer.Verify(playbackScope(id), c.Request.URL.Query()) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+	return false
+}
+
+func (app *application) presignTTL() time.Duration {
+	return time.Duration(app.config.Storage.PresignTTL) * time.Second
+}
+
+// cacheTTL shortens how long a response holding presigned URLs is cached, so
+// it is never served once they have expired.
+func (app *application) cacheTTL(ttl time.Duration) time.Duration {
+	return min(ttl, app.presignTTL()/2)
+}
+
+// objectURL is the address clients read key at.
+func (app *application) objectURL(ctx context.Context, key string) (string, error) {
+	return app.s3.PresignGet(ctx, key, app.presignTTL())
+}
+
+// playbackURLs fills in the URLs of the renditions and the manifests of
+// video. Those of a private video's manifests carry its playback token.
+func (app *application) playbackURLs(ctx context.Context, video *database.FullVideo) error {
+	for _, r := range video.Renditions {
+		link, err := app.objectURL(ctx, r.S3Key)
+		if err != nil {
+			return err
+		}
+		r.URL = link
+	}
+	base := app.videoURL(video.VideoId)
+	video.HlsURL = base + "/hls/" + manifest.MasterPlaylist
+	video.DashURL = base + "/" + manifest.DashManifest
+	if video.Visibility == database.VisibilityPrivate {
+		token := app.signer.Sign(playbackScope(video.VideoId), app.presignTTL()).Encode()
+		video.HlsURL += "?" + token
+		video.DashURL += "?" + token
+	}
+	return nil
+}
+
+// readManifest reads the manifest at key of the video named in the request,
+// answering the request itself if it can't.
+func (app *application) readManifest(c *gin.Context, key func(id uuid.UUID) string) ([]byte, string, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return nil, "", false
+	}
+	visibility, err := app.models.Videos.Visibility(id)
+	if err != nil {
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video"})
+		return nil, "", false
+	}
+	if visibility == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return nil, "", false
+	}
+	if !app.authorizePlayback(c, id, visibility) {
+		return nil, "", false
+	}
+	data, err := storage.ReadAll(c, app.s3, key(id))
+	if err != nil {
+		if storage.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Manifest not found"})
+			return nil, "", false
+		}
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read manifest"})
+		return nil, "", false
+	}
+	return data, key(id), true
+}
+
+// serveManifest sends a manifest with presigned URLs in it, cached for no
+// longer than half their lifetime.
+func (app *application) serveManifest(c *gin.Context, contentType string, data []byte) {
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(app.presignTTL().Seconds())/2))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// getHlsPlaylist serves an HLS playlist with its media URIs presigned. The
+// URIs of other playlists are left relative, with the request's token, so
+// the player loads them through here as well.
+func (app *application) getHlsPlaylist(c *gin.Context) {
+	file := strings.TrimPrefix(c.Param("file"), "/")
+	if path.Ext(file) != ".m3u8" || path.Clean(file) != file || strings.HasPrefix(file, "..") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+	data, key, ok := app.readManifest(c, func(id uuid.UUID) string {
+		return utils.HlsPrefix(id.String()) + "/" + file
+	})
+	if !ok {
+		return
+	}
+	dir := path.Dir(key)
+	query := c.Request.URL.Query()
+	token := url.Values{"expires": {query.Get("expires")}, "signature": {query.Get("signature")}}.Encode()
+	playlist, err := manifest.RewritePlaylist(data, func(uri string) (string, error) {
+		if strings.Contains(uri, "://") {
+			return uri, nil
+		}
+		if path.Ext(uri) == ".m3u8" {
+			if !query.Has("signature") {
+				return uri, nil
+			}
+			return uri + "?" + token, nil
+		}
+		return app.s3.PresignGet(c, path.Join(dir, uri), app.presignTTL())
+	})
+	if err != nil {
+		log.Printf("Playlist %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign playlist"})
+		return
+	}
+	app.serveManifest(c, "application/vnd.apple.mpegurl", playlist)
+}
+
+// getDashManifest serves the DASH manifest with a presigned URL for every
+// segment, since the templates it is written with can't be presigned.
+func (app *application) getDashManifest(c *gin.Context) {
+	data, key, ok := app.readManifest(c, func(id uuid.UUID) string {
+		return id.String() + "/" + manifest.DashManifest
+	})
+	if !ok {
+		return
+	}
+	dir := path.Dir(key)
+	mpd, err := manifest.SignMPD(data, func(uri string) (string, error) {
+		return app.s3.PresignGet(c, path.Join(dir, uri), app.presignTTL())
+	})
+	if err != nil {
+		log.Printf("Manifest %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign manifest"})
+		return
+	}
+	app.serveManifest(c, "application/dash+xml", mpd)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+func runReprocess(args []string) error {
+	conf := config.New()
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	videos := flags.String("video", "", "comma-separated ids of the videos to reprocess")
+	upscale := flags.Bool("up", false, "upscale the videos too")
+	realisticVideo := flags.Bool("real", true, "upscale with the model for realistic video")
+	priority := flags.String("priority", broker.PriorityBulk, "job priority: high, normal or bulk")
+	flags.Parse(args)
+	if _, err := broker.ParsePriority(*priority); err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	for _, s := range strings.Split(*videos, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid video id %q", s)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errors.New("no videos given, use -video")
+	}
+	if err := sharedBackends(conf, "reprocess"); err != nil {
+		return err
+	}
+
+	pool := database.New(conf)
+	defer pool.Close()
+	s3, err := storage.New(conf)
+	if err != nil {
+		return err
+	}
+	jobs, err := broker.New(conf)
+	if err != nil {
+		return err
+	}
+	defer jobs.Close()
+
+	models := database.NewModel(pool)
+	app := &application{
+		models: models,
+		config: conf,
+		s3:     s3,
+		broker: jobs,
+		outbox: outboxRelay(conf, models, jobs),
+	}
+	failed := 0
+	for _, id := range ids {
+		jobId, err := app.reprocessVideo(id, *upscale, *realisticVideo, *priority)
+		if err != nil {
+			log.Printf("Video %s: %v", id, err)
+			failed++
+			continue
+		}
+		log.Printf("Video %s: queued job %s", id, jobId)
+	}
+	// Whatever doesn't go out now is published by the relay of a server.
+	if err := app.outbox.Flush(context.Background()); err != nil {
+		log.Printf("Failed to publish jobs, they stay in the outbox: %v", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d videos were not queued", failed, len(ids))
+	}
+	return nil
+}
+
+// reprocessVideo queues a new job for a processed video. The uploaded source
+// is deleted once a video is done, so the job starts from the best rendition
+// that wasn't produced by upscaling.
+func (app *application) reprocessVideo(id uuid.UUID, upscale, realisticVideo bool, priority string) (uuid.UUID, error) {
+	jobs, err := app.models.Jobs.GetByVideoID(id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(jobs) > 0 && !database.Finished(jobs[0].Status) {
+		return uuid.Nil, fmt.Errorf("job %s is still %s", jobs[0].JobId, jobs[0].Status)
+	}
+
+	video, err := app.models.Videos.GetByID(id, app.videoURL)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if video == nil {
+		return uuid.Nil, errors.New("video not found")
+	}
+
+	var source *database.Rendition
+	for _, r := range video.Renditions {
+		if r.Source == database.RenditionUpscale {
+			continue
+		}
+		if source == nil || r.Height > source.Height {
+			source = r
+		}
+	}
+	if source == nil {
+		return uuid.Nil, errors.New("no rendition to reprocess from")
+	}
+	exists, err := storage.Exists(context.Background(), app.s3, source.S3Key)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !exists {
+		return uuid.Nil, fmt.Errorf("rendition %s is missing from storage", source.S3Key)
+	}
+
+	return app.publishVideoJob(broker.VideoJob{
+		VideoID:        id,
+		FileName:       video.Name,
+		FileExt:        ".mp4",
+		SourceKey:      source.S3Key,
+		Upscale:        upscale,
+		RealisticVideo: realisticVideo,
+		Priority:       priority,
+	}, nil)
+}
@@ -1,21 +1,57 @@
-package main
-
-import (
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-)
-
-func (app *application) routes() http.Handler {
-	router := gin.Default()
-	gin.SetMode(app.config.App.Debug)
-	router.POST("/upload", app.uploadVideo)
-	router.GET("/video", app.getAllVideos)
-	router.GET("/video/:id", app.getVideo)
-	router.PATCH("/video/:id", app.updateVideoPartial)
-	router.DELETE("/video/:id", app.deleteVideo)
-	router.GET("/video/:id/sub", app.getVideoSubtitles)
-	// router.GET("/video/:id/dub", app.getVideoDubbing)
-	return router
-
-}
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) routes() http.Handler {
+	router := gin.Default()
+	gin.SetMode(app.config.App.Debug)
+	router.POST("/upload", app.acceptUploads, app.uploadVideo)
+
+	files := router.Group("/files", app.tusResumable)
+	files.OPTIONS("", app.tusOptions)
+	files.POST("", app.acceptUploads, app.tusCreate)
+	files.HEAD("/:id", app.tusHead)
+	files.PATCH("/:id", app.acceptUploads, app.tusPatch)
+	files.DELETE("/:id", app.tusTerminate)
+
+	router.POST("/uploads", app.acceptUploads, app.createUpload)
+	router.POST("/uploads/:id/complete", app.acceptUploads, app.completeUpload)
+	router.POST("/import", app.acceptUploads, app.importVideo)
+
+	router.GET("/video", app.getAllVideos)
+	router.GET("/video/:id", app.getVideo)
+	router.PATCH("/video/:id", app.updateVideoPartial)
+	router.DELETE("/video/:id", app.deleteVideo)
+	router.GET("/video/:id/sub", app.getVideoSubtitles)
+	router.GET("/video/:id/hls/*file", app.getHlsPlaylist)
+	router.GET("/video/:id/manifest.mpd", app.getDashManifest)
+	router.GET("/video/:id/stream/:quality", app.streamRendition)
+	router.HEAD("/video/:id/stream/:quality", app.streamRendition)
+	router.GET("/video/:id/jobs", app.getVideoJobs)
+	router.GET("/video/:id/progress", app.getVideoProgress)
+	router.GET("/jobs/:id", app.getJob)
+	router.POST("/jobs/:id/cancel", app.cancelJob)
+
+	// The local and memory stores have no endpoint of their own, the API
+	// serves their objects under the path of the public URL.
+	if objects, ok := app.s3.(http.Handler); ok {
+		prefix := "/objects"
+		if u, err := url.Parse(app.config.Storage.PublicURL); err == nil && u.Path != "" {
+			prefix = u.Path
+		}
+		handler := gin.WrapH(http.StripPrefix(prefix, objects))
+		router.GET(prefix+"/*key", handler)
+		router.HEAD(prefix+"/*key", handler)
+	}
+
+	admin := router.Group("/admin", app.requireAdmin)
+	admin.POST("/dlq/replay", app.replayDLQ)
+	// router.GET("/video/:id/dub", app.getVideoDubbing)
+	return router
+
+}
@@ -1,17 +1,163 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"net/http"
-)
-
-func (app *application) serve() error {
-	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", app.host, app.port),
-		Handler: app.routes(),
-	}
-	log.Printf("Starting server on port %d", app.port)
-
-	return server.ListenAndServe()
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/importer"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/tus"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+)
+
+func runServer(args []string) error {
+	conf := config.New()
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	host := flags.String("host", conf.App.Host, "address to listen on")
+	port := flags.Int("port", conf.App.Port, "port to listen on")
+	withWorker := flags.Bool("with-worker", false, "also process video jobs in this process")
+	flags.Parse(args)
+	if !*withWorker {
+		if err := sharedBackends(conf, "server without -with-worker"); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	pool := database.New(conf)
+	defer pool.Close()
+	rdb := cache.New(conf)
+	defer rdb.Close()
+	buckets, err := storage.New(conf)
+	if err != nil {
+		return err
+	}
+	jobs, err := broker.New(conf)
+	if err != nil {
+		return err
+	}
+	defer jobs.Close()
+	models := database.NewModel(pool)
+
+	app := &application{
+		host:     *host,
+		port:     *port,
+		models:   models,
+		config:   conf,
+		s3:       buckets,
+		redis:    rdb,
+		broker:   jobs,
+		outbox:   outboxRelay(conf, models, jobs),
+		uploads:  tus.NewStore(rdb, buckets),
+		importer: importer.New(conf.Upload.MaxSize),
+		signer:   playbackSigner(conf, buckets),
+		stopping: make(chan struct{}),
+	}
+	app.deleter = utils.NewDeleter(models, buckets, rdb, app.outbox)
+
+	// The relay outlives ctx, like in the worker command: the server and a
+	// worker started with it keep emitting events while they drain.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	var relay sync.WaitGroup
+	relay.Go(func() {
+		app.outbox.Run(relayCtx)
+	})
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		app.deleter.Run(ctx, time.Duration(conf.App.DeleteRetryInterval)*time.Second)
+	})
+	if *withWorker {
+		log.Println("Worker started and waiting for messages...")
+		wg.Go(func() {
+			err := utils.StartVideoWorker(ctx, jobs, app.models, app.s3, app.redis, app.outbox, workerOptions(conf))
+			if err != nil {
+				log.Printf("Worker error: %v", err)
+				stop()
+			}
+		})
+	}
+	err = app.serve(ctx)
+	if err != nil {
+		stop()
+	}
+	wg.Wait()
+	stopRelay()
+	relay.Wait()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := app.outbox.Flush(flushCtx); err != nil {
+		log.Printf("Outbox relay error: %v", err)
+	}
+	log.Println("Closing connections...")
+	return err
+}
+
+// serve runs the HTTP server until ctx is cancelled, then stops taking new
+// uploads and drains in-flight requests for up to the shutdown timeout.
+func (app *application) serve(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", app.host, app.port),
+		Handler: app.routes(),
+	}
+	server.RegisterOnShutdown(func() {
+		close(app.stopping)
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on port %d", app.port)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(app.config.App.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server shutdown: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+func (app *application) isStopping() bool {
+	select {
+	case <-app.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// acceptUploads turns new uploads away once shutdown has started, so clients
+// retry against another replica instead of being cut off mid-transfer.
+func (app *application) acceptUploads(c *gin.Context) {
+	if app.isStopping() {
+		c.Header("Retry-After", "30")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+// streamRendition proxies a rendition from the object storage for clients
+// that can't reach it directly. Range requests are passed through to the
+// storage, so browsers can seek without the file being buffered here. A
+// private video's stream takes the token of its playback URLs.
+func (app *application) streamRendition(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video ID"})
+		return
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(c.Param("quality"), "p"))
+	if err != nil || height <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quality"})
+		return
+	}
+	visibility, err := app.models.Videos.Visibility(id)
+	if err != nil {
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get video"})
+		return
+	}
+	if visibility == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+	if !app.authorizePlayback(c, id, visibility) {
+		return
+	}
+	rendition, err := app.models.Renditions.GetReadyByHeight(id, height)
+	if err != nil {
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rendition"})
+		return
+	}
+	if rendition == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rendition not found"})
+		return
+	}
+
+	c.Header("Content-Type", "video/mp4")
+	if visibility == database.VisibilityPrivate {
+		c.Header("Cache-Control", "private")
+	}
+	if err := storage.Serve(c.Writer, c.Request, app.s3, rendition.S3Key); err != nil {
+		c.Writer.Header().Del("Content-Type")
+		if storage.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rendition not found"})
+			return
+		}
+		log.Printf("Video %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read rendition"})
+	}
+}
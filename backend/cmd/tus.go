@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/tus"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+)
+
+func (app *application) tusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tus.Version)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tus.Version {
+		c.Header("Tus-Version", tus.Version)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	c.Next()
+}
+
+func (app *application) tusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tus.Version)
+	c.Header("Tus-Extension", tus.Extensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(app.config.Upload.MaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (app *application) tusCreate(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length"})
+		return
+	}
+	if length > app.config.Upload.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is too large"})
+		return
+	}
+	metadata, err := tus.ParseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := broker.ParsePriority(metadata["priority"]); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := database.ParseVisibility(metadata["visibility"]); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ext := safeExt(metadata["filename"])
+
+	videoId := uuid.New()
+	key := fmt.Sprintf("%s/tmp%s", videoId, ext)
+	if _, err := app.uploads.Create(c, videoId, key, length, metadata); err != nil {
+		log.Printf("tus upload %s: %v", videoId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	c.Header("Location", "/files/"+videoId.String())
+	c.Status(http.StatusCreated)
+}
+
+func (app *application) tusHead(c *gin.Context) {
+	upload, ok := app.loadUpload(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.JobID != uuid.Nil {
+		c.Header("Job-Id", upload.JobID.String())
+	}
+	c.Status(http.StatusOK)
+}
+
+func (app *application) tusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Invalid Content-Type"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset"})
+		return
+	}
+
+	unlock, ok := app.lockUpload(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	upload, ok := app.loadUpload(c)
+	if !ok {
+		return
+	}
+	if upload.Completed() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Upload is already completed"})
+		return
+	}
+	if offset != upload.Offset {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match"})
+		return
+	}
+
+	// A PATCH that failed after the last byte is sent again with an empty
+	// body, which retries assembling the upload and creating its job.
+	if err := app.uploads.Write(c, upload, c.Request.Body); err != nil {
+		log.Printf("tus upload %s: %v", upload.ID, err)
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+		return
+	}
+	if upload.Assembled && !app.finishUpload(c, upload) {
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// finishUpload validates an assembled upload and creates its job, answering
+// the request itself if it can't. It is run again for an upload whose job
+// could not be created, until one is.
+func (app *application) finishUpload(c *gin.Context, upload *tus.Upload) bool {
+	if _, err := app.validateObject(c, upload.Key); err != nil {
+		log.Printf("tus upload %s: %v", upload.ID, err)
+		// Only a video that failed validation is thrown away; anything else is
+		// tried again.
+		var verr *utils.ValidationError
+		if errors.As(err, &verr) {
+			if err := app.uploads.Terminate(c, upload); err != nil {
+				log.Printf("tus upload %s: %v", upload.ID, err)
+			}
+		}
+		respondValidationError(c, err)
+		return false
+	}
+
+	// The job may have been created by a request that failed to record it.
+	jobs, err := app.models.Jobs.GetByVideoID(upload.ID)
+	if err != nil {
+		log.Printf("Video %s: %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue video"})
+		return false
+	}
+	var jobId uuid.UUID
+	if len(jobs) > 0 {
+		jobId = jobs[0].JobId
+	} else {
+		upscale, _ := strconv.ParseBool(upload.Metadata["up"])
+		realisticVideo, err := strconv.ParseBool(upload.Metadata["real"])
+		if err != nil {
+			realisticVideo = true
+		}
+		// Checked when the upload was created.
+		priority, _ := broker.ParsePriority(upload.Metadata["priority"])
+		visibility, _ := database.ParseVisibility(upload.Metadata["visibility"])
+		fileName := upload.Metadata["filename"]
+		name := upload.Metadata["name"]
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		}
+
+		jobId, err = app.enqueueVideoJob(upload.ID, name, safeExt(fileName), upscale, realisticVideo, priority, visibility)
+		if err != nil {
+			log.Printf("Video %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue video"})
+			return false
+		}
+	}
+	if err := app.uploads.Finish(c, upload, jobId); err != nil {
+		log.Printf("tus upload %s: %v", upload.ID, err)
+	}
+	c.Header("Job-Id", jobId.String())
+	return true
+}
+
+func (app *application) tusTerminate(c *gin.Context) {
+	unlock, ok := app.lockUpload(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Loaded under the lock, so a PATCH that finished meanwhile is seen.
+	upload, ok := app.loadUpload(c)
+	if !ok {
+		return
+	}
+
+	if err := app.uploads.Terminate(c, upload); err != nil {
+		log.Printf("tus upload %s: %v", upload.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate upload"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// lockUpload takes the lock of the upload in the path, answering the request
+// itself if it can't.
+func (app *application) lockUpload(c *gin.Context) (func(), bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	unlock, err := app.uploads.Lock(c, id)
+	if err != nil {
+		if errors.Is(err, tus.ErrLocked) {
+			c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		log.Printf("tus upload %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock upload"})
+		return nil, false
+	}
+	return unlock, true
+}
+
+func (app *application) loadUpload(c *gin.Context) (*tus.Upload, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	upload, err := app.uploads.Get(c, id)
+	if err != nil {
+		if errors.Is(err, tus.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload"})
+		return nil, false
+	}
+	return upload, true
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	presignedPartSize = 16 << 20
+	presignedTTL      = 6 * time.Hour
+)
+
+type createUploadRequest struct {
+	FileName       string `json:"file_name" binding:"required"`
+	Size           int64  `json:"size" binding:"required,gt=0"`
+	Name           string `json:"name"`
+	Upscale        bool   `json:"up"`
+	RealisticVideo *bool  `json:"real"`
+	Priority       string `json:"priority"`
+	Visibility     string `json:"visibility"`
+}
+
+type completeUploadRequest struct {
+	Parts []struct {
+		PartNumber int    `json:"part_number" binding:"required,gt=0"`
+		ETag       string `json:"etag" binding:"required"`
+	} `json:"parts" binding:"required,min=1,dive"`
+}
+
+type presignedPart struct {
+	PartNumber int    `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+type presignedUpload struct {
+	VideoId        uuid.UUID `json:"video_id"`
+	Key            string    `json:"key"`
+	UploadId       string    `json:"upload_id"`
+	Size           int64     `json:"size"`
+	PartSize       int64     `json:"part_size"`
+	Name           string    `json:"name"`
+	Ext            string    `json:"ext"`
+	Upscale        bool      `json:"up"`
+	RealisticVideo bool      `json:"real"`
+	Priority       string    `json:"priority"`
+	Visibility     string    `json:"visibility"`
+	// Completed is set once the object was assembled and checked, so a
+	// request that failed after that only has to be retried from there.
+	Completed bool `json:"completed"`
+}
+
+func presignedUploadKey(id uuid.UUID) string {
+	return fmt.Sprintf("upload:%s", id)
+}
+
+func (app *application) createUpload(c *gin.Context) {
+	var req createUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priority, err := broker.ParsePriority(req.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	visibility, err := database.ParseVisibility(req.Visibility)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Size > app.config.Upload.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is too large"})
+		return
+	}
+	ext := safeExt(req.FileName)
+	if req.Name == "" {
+		req.Name = strings.TrimSuffix(filepath.Base(req.FileName), filepath.Ext(req.FileName))
+	}
+
+	partSize := max(int64(presignedPartSize), (req.Size+storage.MaxParts-1)/storage.MaxParts)
+	partCount := int((req.Size + partSize - 1) / partSize)
+
+	videoId := uuid.New()
+	upload := presignedUpload{
+		VideoId:        videoId,
+		Key:            fmt.Sprintf("%s/tmp%s", videoId, ext),
+		Size:           req.Size,
+		PartSize:       partSize,
+		Name:           req.Name,
+		Ext:            ext,
+		Upscale:        req.Upscale,
+		RealisticVideo: req.RealisticVideo == nil || *req.RealisticVideo,
+		Priority:       priority,
+		Visibility:     visibility,
+	}
+
+	uploadId, err := app.s3.NewMultipartUpload(c, upload.Key, storage.VideoObject(videoId.String(), ""))
+	if err != nil {
+		log.Printf("Upload %s: %v", videoId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	upload.UploadId = uploadId
+
+	parts := make([]presignedPart, 0, partCount)
+	for number := 1; number <= partCount; number++ {
+		url, err := app.s3.PresignPart(c, upload.Key, uploadId, number, presignedTTL)
+		if err != nil {
+			log.Printf("Upload %s: %v", videoId, err)
+			_ = app.s3.AbortMultipartUpload(c, upload.Key, uploadId)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to presign upload"})
+			return
+		}
+		parts = append(parts, presignedPart{PartNumber: number, URL: url})
+	}
+
+	state, _ := json.Marshal(upload)
+	if err := app.redis.Set(c, presignedUploadKey(videoId), state, presignedTTL).Err(); err != nil {
+		_ = app.s3.AbortMultipartUpload(c, upload.Key, uploadId)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":  videoId,
+		"video_id":   videoId,
+		"part_size":  partSize,
+		"parts":      parts,
+		"expires_at": time.Now().Add(presignedTTL),
+	})
+}
+
+func (app *application) completeUpload(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload ID"})
+		return
+	}
+	var req completeUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := app.redis.GetDel(c, presignedUploadKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload"})
+		return
+	}
+	var upload presignedUpload
+	if err := json.Unmarshal(state, &upload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload"})
+		return
+	}
+
+	if !upload.Completed {
+		if !app.assembleUpload(c, id, &upload, req, state) {
+			return
+		}
+	}
+
+	if _, err := app.validateObject(c, upload.Key); err != nil {
+		log.Printf("Upload %s: validation failed: %v", id, err)
+		// Only a video that failed validation is thrown away; otherwise it is
+		// kept for the client to complete the upload again.
+		var verr *utils.ValidationError
+		if errors.As(err, &verr) {
+			_ = app.s3.Delete(c, upload.Key)
+		} else {
+			app.keepUpload(c, &upload)
+		}
+		respondValidationError(c, err)
+		return
+	}
+
+	jobId, err := app.enqueueVideoJob(upload.VideoId, upload.Name, upload.Ext, upload.Upscale, upload.RealisticVideo, upload.Priority, upload.Visibility)
+	if err != nil {
+		log.Printf("Video %s: %v", upload.VideoId, err)
+		app.keepUpload(c, &upload)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue video"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Видео успешно загружено",
+		"video_id": upload.VideoId,
+		"job_id":   jobId,
+	})
+}
+
+// assembleUpload completes the multipart upload with the parts in req and
+// checks the object it made, answering the request itself if it can't. The
+// saved state is put back while the client can still fix the request.
+func (app *application) assembleUpload(c *gin.Context, id uuid.UUID, upload *presignedUpload, req completeUploadRequest, state []byte) bool {
+	// Only the parts that were presigned, each once and in order.
+	if len(req.Parts) != int((upload.Size+upload.PartSize-1)/upload.PartSize) {
+		app.redis.Set(c, presignedUploadKey(id), state, presignedTTL)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parts do not match the upload"})
+		return false
+	}
+	parts := make([]storage.Part, 0, len(req.Parts))
+	for i, p := range req.Parts {
+		if p.PartNumber != i+1 {
+			app.redis.Set(c, presignedUploadKey(id), state, presignedTTL)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parts do not match the upload"})
+			return false
+		}
+		parts = append(parts, storage.Part{Number: p.PartNumber, ETag: p.ETag})
+	}
+	if err := app.s3.CompleteMultipartUpload(c, upload.Key, upload.UploadId, parts); err != nil {
+		log.Printf("Upload %s: %v", id, err)
+		app.redis.Set(c, presignedUploadKey(id), state, presignedTTL)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to complete upload"})
+		return false
+	}
+
+	// Presigned part URLs don't limit how much a part holds, so the size
+	// declared when the upload was created is only checked here.
+	info, err := app.s3.Stat(c, upload.Key)
+	if err != nil {
+		log.Printf("Upload %s: %v", id, err)
+		_ = app.s3.Delete(c, upload.Key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete upload"})
+		return false
+	}
+	if info.Size != upload.Size || info.Size > app.config.Upload.MaxSize {
+		log.Printf("Upload %s: got %d bytes, expected %d", id, info.Size, upload.Size)
+		_ = app.s3.Delete(c, upload.Key)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload does not match its declared size"})
+		return false
+	}
+	upload.Completed = true
+	return true
+}
+
+// keepUpload saves an assembled upload that couldn't be turned into a job, so
+// completing it again picks up from its object. The request may have been
+// cancelled already, which is what failed it.
+func (app *application) keepUpload(c *gin.Context, upload *presignedUpload) {
+	state, _ := json.Marshal(upload)
+	if err := app.redis.Set(context.WithoutCancel(c), presignedUploadKey(upload.VideoId), state, presignedTTL).Err(); err != nil {
+		log.Printf("Upload %s: %v", upload.VideoId, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+)
+
+var safeExtPattern = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+
+// safeExt only names the stored object; the content itself is validated by
+// sniffing and ffprobe, never by the extension.
+func safeExt(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !safeExtPattern.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
+
+func (app *application) validationLimits() utils.ValidationLimits {
+	return utils.ValidationLimits{
+		MaxDuration: float64(app.config.Upload.MaxDuration),
+		MaxWidth:    app.config.Upload.MaxWidth,
+		MaxHeight:   app.config.Upload.MaxHeight,
+		MaxStreams:  app.config.Upload.MaxStreams,
+	}
+}
+
+func (app *application) validateFile(ctx context.Context, path string) (utils.Container, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return utils.Container{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, utils.SniffSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return utils.Container{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return utils.ValidateMedia(ctx, path, header[:n], app.validationLimits())
+}
+
+func (app *application) validateObject(ctx context.Context, key string) (utils.Container, error) {
+	header, err := storage.ReadRange(ctx, app.s3, key, 0, utils.SniffSize)
+	if err != nil {
+		return utils.Container{}, err
+	}
+	objectURL, err := app.s3.PresignGet(ctx, key, 15*time.Minute)
+	if err != nil {
+		return utils.Container{}, err
+	}
+	return utils.ValidateMedia(ctx, objectURL, header, app.validationLimits())
+}
+
+func respondValidationError(c *gin.Context, err error) {
+	var verr *utils.ValidationError
+	if errors.As(err, &verr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "Видео не прошло проверку",
+			"reasons": verr.Reasons,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate video"})
+}
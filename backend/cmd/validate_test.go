@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/tus"
+	"github.com/redis/go-redis/v9"
+)
+
+// mp4Data is enough of an mp4 to be sniffed as one.
+var mp4Data = []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2avc1mp41")
+
+// newTestApp returns an application on the memory store and a miniredis,
+// without a database or a broker.
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	s3 := storage.NewMemory("http://objects.test")
+	return &application{
+		config:  config.New(),
+		s3:      s3,
+		redis:   rdb,
+		uploads: tus.NewStore(rdb, s3),
+	}
+}
+
+// testContext returns a gin context for a request whose context ends after
+// timeout.
+func testContext(t *testing.T, method, target string, body []byte, timeout time.Duration) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, engine := gin.CreateTestContext(w)
+	engine.ContextWithFallback = true
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	c.Request = httptest.NewRequest(method, target, bytes.NewReader(body)).WithContext(ctx)
+	return c, w
+}
+
+// fakeFFprobe puts an ffprobe running script first in PATH.
+func fakeFFprobe(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFinishUploadKeepsUploadOnProbeTimeout(t *testing.T) {
+	fakeFFprobe(t, "exec sleep 10")
+	app := newTestApp(t)
+	ctx := context.Background()
+	id := uuid.New()
+	upload, err := app.uploads.Create(ctx, id, id.String()+"/tmp.mp4", int64(len(mp4Data)), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.uploads.Write(ctx, upload, bytes.NewReader(mp4Data)); err != nil {
+		t.Fatal(err)
+	}
+	if !upload.Assembled {
+		t.Fatal("upload was not assembled")
+	}
+
+	c, w := testContext(t, http.MethodPatch, "/files/"+id.String(), nil, 200*time.Millisecond)
+	if app.finishUpload(c, upload) {
+		t.Fatal("finishUpload() = true")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if _, err := app.uploads.Get(ctx, id); err != nil {
+		t.Errorf("upload is gone: %v", err)
+	}
+	if ok, err := storage.Exists(ctx, app.s3, upload.Key); !ok || err != nil {
+		t.Errorf("object is gone: %v", err)
+	}
+}
+
+func TestFinishUploadTerminatesRejectedUpload(t *testing.T) {
+	fakeFFprobe(t, "echo 'Invalid data found when processing input' >&2; exit 1")
+	app := newTestApp(t)
+	ctx := context.Background()
+	id := uuid.New()
+	upload, err := app.uploads.Create(ctx, id, id.String()+"/tmp.mp4", int64(len(mp4Data)), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.uploads.Write(ctx, upload, bytes.NewReader(mp4Data)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, w := testContext(t, http.MethodPatch, "/files/"+id.String(), nil, 5*time.Second)
+	if app.finishUpload(c, upload) {
+		t.Fatal("finishUpload() = true")
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if _, err := app.uploads.Get(ctx, id); err != tus.ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, tus.ErrNotFound)
+	}
+	if ok, _ := storage.Exists(ctx, app.s3, upload.Key); ok {
+		t.Error("the rejected object was kept")
+	}
+}
+
+func TestCompleteUploadKeepsObjectOnProbeTimeout(t *testing.T) {
+	fakeFFprobe(t, "exec sleep 10")
+	app := newTestApp(t)
+	ctx := context.Background()
+	id := uuid.New()
+	upload := presignedUpload{
+		VideoId:  id,
+		Key:      id.String() + "/tmp.mp4",
+		Size:     int64(len(mp4Data)),
+		PartSize: presignedPartSize,
+		Ext:      ".mp4",
+	}
+	upload.UploadId, _ = app.s3.NewMultipartUpload(ctx, upload.Key, storage.PutOptions{})
+	part, err := app.s3.PutPart(ctx, upload.Key, upload.UploadId, 1, bytes.NewReader(mp4Data), upload.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := json.Marshal(upload)
+	app.redis.Set(ctx, presignedUploadKey(id), state, presignedTTL)
+
+	body := `{"parts": [{"part_number": 1, "etag": "` + part.ETag + `"}]}`
+	c, w := testContext(t, http.MethodPost, "/uploads/"+id.String()+"/complete", []byte(body), 200*time.Millisecond)
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	app.completeUpload(c)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+	if ok, err := storage.Exists(ctx, app.s3, upload.Key); !ok || err != nil {
+		t.Errorf("object is gone: %v", err)
+	}
+	saved, err := app.redis.Get(ctx, presignedUploadKey(id)).Result()
+	if err != nil {
+		t.Fatalf("upload is gone: %v", err)
+	}
+	if !strings.Contains(saved, `"completed":true`) {
+		t.Errorf("saved upload = %s, want it completed", saved)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/internal/utils"
+)
+
+func runWorker(args []string) error {
+	conf := config.New()
+	opts := workerOptions(conf)
+
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	flags.IntVar(&opts.JobRetry.Attempts, "max-attempts", opts.JobRetry.Attempts, "attempts per job before it goes to the DLQ, 0 for no limit")
+	flags.DurationVar(&opts.Grace, "grace", opts.Grace, "how long running jobs may continue after a shutdown signal")
+	flags.IntVar(&opts.MaxJobs, "jobs", opts.MaxJobs, "number of jobs processed at the same time")
+	flags.IntVar(&opts.FFmpegCapacity, "ffmpeg-capacity", opts.FFmpegCapacity, "weight of ffmpeg work run at once, a 720p encode weighs 1; 0 for one per CPU")
+	flags.Parse(args)
+	if err := sharedBackends(conf, "worker"); err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	pool := database.New(conf)
+	defer pool.Close()
+	rdb := cache.New(conf)
+	defer rdb.Close()
+	s3, err := storage.New(conf)
+	if err != nil {
+		return err
+	}
+	jobs, err := broker.New(conf)
+	if err != nil {
+		return err
+	}
+	defer jobs.Close()
+
+	models := database.NewModel(pool)
+	outbox := outboxRelay(conf, models, jobs)
+	// The relay outlives ctx, running jobs keep emitting events during the
+	// grace period.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		outbox.Run(relayCtx)
+	})
+
+	log.Println("Worker started and waiting for messages...")
+	err = utils.StartVideoWorker(ctx, jobs, models, s3, rdb, outbox, opts)
+	stopRelay()
+	wg.Wait()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := outbox.Flush(flushCtx); err != nil {
+		log.Printf("Outbox relay error: %v", err)
+	}
+	log.Println("Closing connections...")
+	return err
+}
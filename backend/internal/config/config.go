@@ -1,99 +1,182 @@
-package config
-
-import (
-	"os"
-	"strconv"
-)
-
-type AppConfig struct {
-	Host  string
-	Port  int
-	Debug string
-}
-type PgConfig struct {
-	Host string
-	Port int
-	User string
-	Pass string
-	Name string
-}
-
-type RedisConfig struct {
-	Host string
-	Port int
-	Pass string
-}
-type S3Config struct {
-	AccessKeyID     string
-	SecretAccessKey string
-	EndpointURL     string
-	BucketName      string
-}
-
-type ApiConfig struct {
-	BaseURL string
-}
-
-type KafkaConfig struct {
-	Host string
-	Port int
-}
-type Config struct {
-	App      AppConfig
-	Postgres PgConfig
-	Redis    RedisConfig
-	S3       S3Config
-	Api      ApiConfig
-	Kafka    KafkaConfig
-}
-
-func New() *Config {
-	// if err := godotenv.Load(".env.dev"); err != nil {
-	// 	panic(err)
-	// }
-	return &Config{
-		App: AppConfig{
-			Host:  getEnv("APP_HOST", "localhost"),
-			Port:  getEnvAsInt("APP_PORT", 8000),
-			Debug: getEnv("APP_DEBUG", "release"),
-		},
-		Postgres: PgConfig{
-			Host: getEnv("DB_HOST", ""),
-			Port: getEnvAsInt("DB_PORT", 5432),
-			User: getEnv("DB_USER", ""),
-			Pass: getEnv("DB_PASS", ""),
-			Name: getEnv("DB_NAME", ""),
-		},
-		Redis: RedisConfig{
-			Host: getEnv("REDIS_HOST", "localhost"),
-			Port: getEnvAsInt("REDIS_PORT", 6379),
-			Pass: getEnv("REDIS_PASS", ""),
-		},
-		S3: S3Config{
-			AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
-			SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
-			EndpointURL:     getEnv("S3_ENDPOINT_URL", ""),
-			BucketName:      getEnv("S3_BUCKET_NAME", ""),
-		},
-		Api: ApiConfig{
-			BaseURL: getEnv("BASE_URL", ""),
-		},
-		Kafka: KafkaConfig{
-			Host: getEnv("KAFKA_HOST", "localhost"),
-			Port: getEnvAsInt("KAFKA_PORT", 9092),
-		},
-	}
-}
-func getEnv(key, defaultVal string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
-	}
-	return defaultVal
-}
-func getEnvAsInt(key string, defaultVal int) int {
-	valueStr := getEnv(key, "")
-	if value, err := strconv.Atoi(valueStr); err == nil {
-		return value
-	}
-	return defaultVal
-}
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+type AppConfig struct {
+	Host            string
+	Port            int
+	Debug           string
+	AdminToken      string
+	ShutdownTimeout int
+	// DeleteRetryInterval is how often, in seconds, video deletions that
+	// failed part-way are retried.
+	DeleteRetryInterval int
+	// PublicURL is where clients reach the API, for the links to the
+	// manifests it serves.
+	PublicURL string
+	// SigningKey signs the playback URLs of private videos. The instances
+	// serving one API must share it.
+	SigningKey string
+}
+type PgConfig struct {
+	Host string
+	Port int
+	User string
+	Pass string
+	Name string
+}
+
+type RedisConfig struct {
+	Host string
+	Port int
+	Pass string
+}
+type S3Config struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	EndpointURL     string
+	BucketName      string
+}
+
+type StorageConfig struct {
+	// Driver is "s3", "local" or "memory". The memory store only works when
+	// the API and the worker run in the same process.
+	Driver string
+	// Dir is where the local store keeps the objects.
+	Dir string
+	// PublicURL is where the API serves the objects of the local and memory
+	// stores.
+	PublicURL string
+	// PresignTTL is how long, in seconds, the presigned URLs handed out for
+	// private videos stay valid.
+	PresignTTL int
+}
+
+type ApiConfig struct {
+	BaseURL string
+}
+
+type KafkaConfig struct {
+	// Driver is "kafka" or "memory"; memory only works when the API and the
+	// worker run in the same process.
+	Driver string
+	Host   string
+	Port   int
+	// OutboxInterval is how often, in seconds, the outbox is checked for
+	// messages that weren't published right away.
+	OutboxInterval int
+	// DLQReplayWait is how long, in seconds, a DLQ replay waits for each
+	// message it reads.
+	DLQReplayWait int
+}
+
+type UploadConfig struct {
+	MaxSize     int64
+	MaxDuration int
+	MaxWidth    int
+	MaxHeight   int
+	MaxStreams  int
+}
+
+type WorkerConfig struct {
+	MaxAttempts    int
+	RetryBaseDelay int
+	RetryMaxDelay  int
+	GracePeriod    int
+	MaxJobs        int
+	FFmpegCapacity int
+}
+type Config struct {
+	App      AppConfig
+	Postgres PgConfig
+	Redis    RedisConfig
+	S3       S3Config
+	Storage  StorageConfig
+	Api      ApiConfig
+	Kafka    KafkaConfig
+	Upload   UploadConfig
+	Worker   WorkerConfig
+}
+
+func New() *Config {
+	// if err := godotenv.Load(".env.dev"); err != nil {
+	// 	panic(err)
+	// }
+	return &Config{
+		App: AppConfig{
+			Host:                getEnv("APP_HOST", "localhost"),
+			Port:                getEnvAsInt("APP_PORT", 8000),
+			Debug:               getEnv("APP_DEBUG", "release"),
+			AdminToken:          getEnv("APP_ADMIN_TOKEN", ""),
+			ShutdownTimeout:     getEnvAsInt("APP_SHUTDOWN_TIMEOUT", 30),
+			DeleteRetryInterval: getEnvAsInt("APP_DELETE_RETRY_INTERVAL", 60),
+			PublicURL:           getEnv("APP_PUBLIC_URL", "http://localhost:8000"),
+			SigningKey:          getEnv("APP_SIGNING_KEY", ""),
+		},
+		Postgres: PgConfig{
+			Host: getEnv("DB_HOST", ""),
+			Port: getEnvAsInt("DB_PORT", 5432),
+			User: getEnv("DB_USER", ""),
+			Pass: getEnv("DB_PASS", ""),
+			Name: getEnv("DB_NAME", ""),
+		},
+		Redis: RedisConfig{
+			Host: getEnv("REDIS_HOST", "localhost"),
+			Port: getEnvAsInt("REDIS_PORT", 6379),
+			Pass: getEnv("REDIS_PASS", ""),
+		},
+		S3: S3Config{
+			AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+			EndpointURL:     getEnv("S3_ENDPOINT_URL", ""),
+			BucketName:      getEnv("S3_BUCKET_NAME", ""),
+		},
+		Storage: StorageConfig{
+			Driver:     getEnv("STORAGE_DRIVER", "s3"),
+			Dir:        getEnv("STORAGE_DIR", "./data"),
+			PublicURL:  getEnv("STORAGE_PUBLIC_URL", "http://localhost:8000/objects"),
+			PresignTTL: getEnvAsInt("STORAGE_PRESIGN_TTL", 3600),
+		},
+		Api: ApiConfig{
+			BaseURL: getEnv("BASE_URL", ""),
+		},
+		Kafka: KafkaConfig{
+			Driver:         getEnv("BROKER_DRIVER", "kafka"),
+			Host:           getEnv("KAFKA_HOST", "localhost"),
+			Port:           getEnvAsInt("KAFKA_PORT", 9092),
+			OutboxInterval: getEnvAsInt("OUTBOX_POLL_INTERVAL", 5),
+			DLQReplayWait:  getEnvAsInt("DLQ_REPLAY_WAIT", 10),
+		},
+		Upload: UploadConfig{
+			MaxSize:     int64(getEnvAsInt("UPLOAD_MAX_SIZE", 20<<30)),
+			MaxDuration: getEnvAsInt("UPLOAD_MAX_DURATION", 4*60*60),
+			MaxWidth:    getEnvAsInt("UPLOAD_MAX_WIDTH", 7680),
+			MaxHeight:   getEnvAsInt("UPLOAD_MAX_HEIGHT", 4320),
+			MaxStreams:  getEnvAsInt("UPLOAD_MAX_STREAMS", 16),
+		},
+		Worker: WorkerConfig{
+			MaxAttempts:    getEnvAsInt("WORKER_MAX_ATTEMPTS", 5),
+			RetryBaseDelay: getEnvAsInt("WORKER_RETRY_BASE_DELAY", 10),
+			RetryMaxDelay:  getEnvAsInt("WORKER_RETRY_MAX_DELAY", 300),
+			GracePeriod:    getEnvAsInt("WORKER_GRACE_PERIOD", 300),
+			MaxJobs:        getEnvAsInt("WORKER_MAX_JOBS", 2),
+			FFmpegCapacity: getEnvAsInt("WORKER_FFMPEG_CAPACITY", 0),
+		},
+	}
+}
+func getEnv(key, defaultVal string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultVal
+}
+func getEnvAsInt(key string, defaultVal int) int {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.Atoi(valueStr); err == nil {
+		return value
+	}
+	return defaultVal
+}
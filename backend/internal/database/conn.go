@@ -9,14 +9,18 @@ import (
 	"github.com/ksamf/video-upscaling/backend/internal/config"
 )
 
-func New(conf *config.Config) *pgxpool.Pool {
-	url := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+// URL is the connection string of the database in conf.
+func URL(conf *config.Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		conf.Postgres.User,
 		conf.Postgres.Pass,
 		conf.Postgres.Host,
 		conf.Postgres.Port,
 		conf.Postgres.Name)
-	pool, err := pgxpool.New(context.Background(), url)
+}
+
+func New(conf *config.Config) *pgxpool.Pool {
+	pool, err := pgxpool.New(context.Background(), URL(conf))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
 		os.Exit(1)
@@ -1,198 +1,290 @@
-package database
-
-import (
-	"context"
-	"database/sql"
-	"errors"
-	"fmt"
-	"slices"
-	"strconv"
-	"time"
-
-	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-)
-
-type VideoModel struct {
-	Pool *pgxpool.Pool
-}
-
-type Video struct {
-	VideoId    uuid.UUID  `json:"video_id"`
-	Name       string     `json:"name"`
-	VideoPath  string     `json:"video_path"`
-	LanguageId int        `json:"language_id"`
-	Quality    int        `json:"quality"`
-	CreatedAt  *time.Time `json:"created_at"`
-	UpdatedAt  *time.Time `json:"update_at"`
-}
-type FullVideo struct {
-	VideoId   uuid.UUID  `json:"video_id"`
-	Name      string     `json:"name"`
-	VideoPath string     `json:"video_path"`
-	Language  string     `json:"language"`
-	Qualities []int      `json:"qualities"`
-	CreatedAt *time.Time `json:"created_at"`
-	UpdatedAt *time.Time `json:"update_at"`
-}
-
-var standardHeights = []int{144, 240, 360, 480, 720, 1080, 1440, 2160, 4320}
-
-func (m *VideoModel) Insert(video *Video) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
-
-	query := "INSERT INTO videos(video_id, name, language_id, quality) VALUES($1, $2, $3, $4)"
-	_, err := m.Pool.Exec(ctx, query, video.VideoId, video.Name, video.LanguageId, video.Quality)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (m *VideoModel) GetAll(limit, offset string, getURL func(uuid.UUID) string) ([]*Video, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	intLimit, err := strconv.Atoi(limit)
-	if err != nil {
-		intLimit = 10
-	}
-	intOffset, err := strconv.Atoi(offset)
-	if err != nil {
-		intOffset = 0
-	}
-	query := "SELECT video_id, name, language_id, quality, created_at, updated_at FROM videos LIMIT $1 OFFSET $2"
-	rows, err := m.Pool.Query(ctx, query, intLimit, intOffset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var videos []*Video
-	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.VideoId,
-			&video.Name,
-			&video.LanguageId,
-			&video.Quality,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-		); err != nil {
-			return nil, err
-		}
-		video.VideoPath = getURL(video.VideoId)
-		videos = append(videos, &video)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return videos, nil
-}
-
-func (m *VideoModel) GetByID(id uuid.UUID, getURL func(uuid.UUID) string) (*FullVideo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	query := `
-		SELECT 
-			v.video_id,
-			v.name,
-			l.code,
-			v.quality,
-			v.created_at,
-			v.updated_at
-		FROM videos AS v
-		LEFT JOIN languages AS l ON l.language_id = v.language_id
-		WHERE v.video_id = $1;
-	`
-
-	row := m.Pool.QueryRow(ctx, query, id)
-
-	var v FullVideo
-	var q int
-	var lang sql.NullString
-
-	err := row.Scan(&v.VideoId, &v.Name, &lang, &q, &v.CreatedAt, &v.UpdatedAt)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	if lang.Valid {
-		v.Language = lang.String
-	} else {
-		v.Language = ""
-	}
-
-	v.Qualities = standardHeights[0 : slices.Index(standardHeights, q)+3]
-	v.VideoPath = getURL(v.VideoId)
-	return &v, nil
-}
-
-func (m *VideoModel) UpdatePartial(id uuid.UUID, field string, value any) error {
-	validFields := map[string]bool{
-		"language_id": true,
-		"quality":     true,
-		"name":        true,
-	}
-	if !validFields[field] {
-		return fmt.Errorf("invalid field: %s", field)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	query := fmt.Sprintf(`UPDATE videos SET "%s" = $1 WHERE video_id = $2`, field)
-	res, err := m.Pool.Exec(ctx, query, value, id)
-	if err != nil {
-		return err
-	}
-
-	rows := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("no rows updated for video_id %s", id)
-	}
-
-	return nil
-}
-func (m *VideoModel) Delete(id uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	query := "DELETE FROM videos WHERE video_id=$1"
-	_, err := m.Pool.Exec(ctx, query, id)
-	return err
-}
-
-func (m *VideoModel) GetLanguageId(lang string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	query := "SELECT language_id FROM languages WHERE code=$1"
-	row := m.Pool.QueryRow(ctx, query, lang)
-	var langId int
-	err := row.Scan(&langId)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, nil
-		}
-		return 0, err
-	}
-	return langId, nil
-}
-
-func GetAllRes(base int) []int {
-	var result []int
-	for i, h := range standardHeights {
-		if standardHeights[i] == base {
-			result = append(result, standardHeights[i+1])
-			result = append(result, standardHeights[i+2])
-		}
-		if h <= base {
-			result = append(result, h)
-		}
-	}
-	return result
-}
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// A video is uploaded until its first job is done. Videos that failed before
+// anything was produced are marked failed. A video being deleted is hidden
+// from the API until its deletion completes, see DeletionModel.
+const (
+	VideoUploaded = "uploaded"
+	VideoReady    = "ready"
+	VideoFailed   = "failed"
+	VideoDeleting = "deleting"
+)
+
+// Private videos are left out of the video list, and their manifests and
+// stream are only served with a signed playback token.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+var (
+	ErrInvalidVisibility = errors.New("visibility must be public or private")
+	// ErrVideoNotFound is returned when a video a job works on is gone,
+	// because it was deleted.
+	ErrVideoNotFound = errors.New("video not found")
+)
+
+// ParseVisibility validates a visibility given by a client; empty means
+// public.
+func ParseVisibility(s string) (string, error) {
+	switch s {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityPrivate:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidVisibility, s)
+}
+
+type VideoModel struct {
+	Pool *pgxpool.Pool
+}
+
+type Video struct {
+	VideoId    uuid.UUID  `json:"video_id"`
+	Name       string     `json:"name"`
+	VideoPath  string     `json:"video_path"`
+	LanguageId int        `json:"language_id"`
+	Quality    int        `json:"quality"`
+	Status     string     `json:"status"`
+	Visibility string     `json:"visibility"`
+	CreatedAt  *time.Time `json:"created_at"`
+	UpdatedAt  *time.Time `json:"update_at"`
+}
+type FullVideo struct {
+	VideoId    uuid.UUID    `json:"video_id"`
+	Name       string       `json:"name"`
+	VideoPath  string       `json:"video_path"`
+	Language   string       `json:"language"`
+	Qualities  []int        `json:"qualities"`
+	Renditions []*Rendition `json:"renditions"`
+	HlsURL     string       `json:"hls_url"`
+	DashURL    string       `json:"dash_url"`
+	Metadata   *MediaInfo   `json:"metadata,omitempty"`
+	Status     string       `json:"status"`
+	Visibility string       `json:"visibility"`
+	CreatedAt  *time.Time   `json:"created_at"`
+	UpdatedAt  *time.Time   `json:"update_at"`
+}
+
+// SetProcessed records the language and the quality found by processing a
+// video. It returns ErrVideoNotFound if the video was deleted meanwhile.
+func (m *VideoModel) SetProcessed(id uuid.UUID, languageId, quality int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `
+		UPDATE videos SET language_id = NULLIF($2, 0), quality = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE video_id = $1 AND status <> $4
+	`
+	res, err := m.Pool.Exec(ctx, query, id, languageId, quality, VideoDeleting)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrVideoNotFound, id)
+	}
+	return nil
+}
+
+func (m *VideoModel) GetAll(limit, offset string, getURL func(uuid.UUID) string) ([]*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	intLimit, err := strconv.Atoi(limit)
+	if err != nil {
+		intLimit = 10
+	}
+	intOffset, err := strconv.Atoi(offset)
+	if err != nil {
+		intOffset = 0
+	}
+	query := `
+		SELECT video_id, name, COALESCE(language_id, 0), COALESCE(quality, 0), status, visibility, created_at, updated_at
+		FROM videos WHERE status <> $3 AND visibility <> $4 LIMIT $1 OFFSET $2
+	`
+	// Private videos are only reachable by whoever was given their ID.
+	rows, err := m.Pool.Query(ctx, query, intLimit, intOffset, VideoDeleting, VisibilityPrivate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var videos []*Video
+	for rows.Next() {
+		var video Video
+		if err := rows.Scan(
+			&video.VideoId,
+			&video.Name,
+			&video.LanguageId,
+			&video.Quality,
+			&video.Status,
+			&video.Visibility,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		video.VideoPath = getURL(video.VideoId)
+		videos = append(videos, &video)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+func (m *VideoModel) GetByID(id uuid.UUID, getURL func(uuid.UUID) string) (*FullVideo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT 
+			v.video_id,
+			v.name,
+			l.code,
+			COALESCE(v.quality, 0),
+			v.status,
+			v.visibility,
+			v.created_at,
+			v.updated_at
+		FROM videos AS v
+		LEFT JOIN languages AS l ON l.language_id = v.language_id
+		WHERE v.video_id = $1 AND v.status <> $2;
+	`
+
+	row := m.Pool.QueryRow(ctx, query, id, VideoDeleting)
+
+	var v FullVideo
+	var q int
+	var lang sql.NullString
+
+	err := row.Scan(&v.VideoId, &v.Name, &lang, &q, &v.Status, &v.Visibility, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if lang.Valid {
+		v.Language = lang.String
+	} else {
+		v.Language = ""
+	}
+
+	renditions := RenditionModel{Pool: m.Pool}
+	v.Renditions, err = renditions.GetReady(id)
+	if err != nil {
+		return nil, err
+	}
+	v.Qualities = make([]int, 0, len(v.Renditions))
+	for _, r := range v.Renditions {
+		v.Qualities = append(v.Qualities, r.Height)
+	}
+	v.VideoPath = getURL(v.VideoId)
+	return &v, nil
+}
+
+func (m *VideoModel) UpdatePartial(id uuid.UUID, field string, value any) error {
+	validFields := map[string]bool{
+		"language_id": true,
+		"quality":     true,
+		"name":        true,
+		"visibility":  true,
+	}
+	if !validFields[field] {
+		return fmt.Errorf("invalid field: %s", field)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`UPDATE videos SET "%s" = $1 WHERE video_id = $2`, field)
+	res, err := m.Pool.Exec(ctx, query, value, id)
+	if err != nil {
+		return err
+	}
+
+	rows := res.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("no rows updated for video_id %s", id)
+	}
+
+	return nil
+}
+
+// SetStatus changes the status of a video unless it is being deleted.
+func (m *VideoModel) SetStatus(id uuid.UUID, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := "UPDATE videos SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE video_id = $2 AND status <> $3"
+	_, err := m.Pool.Exec(ctx, query, status, id, VideoDeleting)
+	return err
+}
+
+// Visibility returns the visibility of a video, or "" if there is no such
+// video.
+func (m *VideoModel) Visibility(id uuid.UUID) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	var visibility string
+	query := "SELECT visibility FROM videos WHERE video_id = $1 AND status <> $2"
+	err := m.Pool.QueryRow(ctx, query, id, VideoDeleting).Scan(&visibility)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return visibility, err
+}
+
+func (m *VideoModel) Delete(id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := deleteVideo(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func deleteVideo(ctx context.Context, tx pgx.Tx, id uuid.UUID) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM renditions WHERE video_id=$1", id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, "DELETE FROM videos WHERE video_id=$1", id)
+	return err
+}
+
+func (m *VideoModel) GetLanguageId(lang string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	query := "SELECT language_id FROM languages WHERE code=$1"
+	row := m.Pool.QueryRow(ctx, query, lang)
+	var langId int
+	err := row.Scan(&langId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return langId, nil
+}
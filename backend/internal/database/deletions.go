@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The steps of a video deletion, in the order they run. A deletion records the
+// step it has to run next, so a retry carries on where the last attempt
+// failed.
+const (
+	DeleteJobs    = "jobs"
+	DeleteObjects = "objects"
+	DeleteCache   = "cache"
+	DeleteRecord  = "record"
+	DeleteDone    = "done"
+)
+
+var deletionSteps = []string{DeleteJobs, DeleteObjects, DeleteCache, DeleteRecord, DeleteDone}
+
+// NextDeletionStep returns the step that runs after step.
+func NextDeletionStep(step string) string {
+	for i, s := range deletionSteps[:len(deletionSteps)-1] {
+		if s == step {
+			return deletionSteps[i+1]
+		}
+	}
+	return DeleteDone
+}
+
+// DeletionModel tracks videos being deleted until every step has run.
+type DeletionModel struct {
+	Pool *pgxpool.Pool
+}
+
+type Deletion struct {
+	VideoId   uuid.UUID  `json:"video_id"`
+	Step      string     `json:"step"`
+	Attempts  int        `json:"attempts"`
+	LastError *string    `json:"last_error,omitempty"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"update_at"`
+	DoneAt    *time.Time `json:"done_at"`
+}
+
+// Done reports whether every step of the deletion has run.
+func (d *Deletion) Done() bool {
+	return d.DoneAt != nil
+}
+
+const deletionColumns = "video_id, step, attempts, last_error, created_at, updated_at, done_at"
+
+// Start marks the video as deleting and records its deletion as a first
+// attempt. Starting a deletion that was recorded already counts as another
+// attempt of it. Start returns nil if there is neither the video nor a
+// deletion of it.
+func (m *DeletionModel) Start(videoID uuid.UUID) (*Deletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "UPDATE videos SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE video_id = $2"
+	res, err := tx.Exec(ctx, query, VideoDeleting, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected() == 0 {
+		// The row is only gone once the deletion is done.
+		query = "SELECT " + deletionColumns + " FROM video_deletions WHERE video_id = $1"
+		d, err := scanDeletion(tx.QueryRow(ctx, query, videoID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return d, err
+	}
+
+	query = `
+		INSERT INTO video_deletions(video_id, step, attempts) VALUES($1, $2, 1)
+		ON CONFLICT (video_id) DO UPDATE SET
+			attempts = video_deletions.attempts + 1,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + deletionColumns
+	d, err := scanDeletion(tx.QueryRow(ctx, query, videoID, DeleteJobs))
+	if err != nil {
+		return nil, err
+	}
+	return d, tx.Commit(ctx)
+}
+
+// Get returns the deletion of a video, or nil if it was never deleted.
+func (m *DeletionModel) Get(videoID uuid.UUID) (*Deletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := "SELECT " + deletionColumns + " FROM video_deletions WHERE video_id = $1"
+	d, err := scanDeletion(m.Pool.QueryRow(ctx, query, videoID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return d, err
+}
+
+// Claim returns up to limit unfinished deletions whose last attempt started
+// more than lease ago, and counts this as another attempt of each. The lease
+// keeps other replicas from running a deletion while it is in progress.
+func (m *DeletionModel) Claim(limit int, lease time.Duration) ([]*Deletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE video_deletions SET attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE video_id IN (
+			SELECT video_id FROM video_deletions
+			WHERE done_at IS NULL AND updated_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'
+			ORDER BY updated_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + deletionColumns
+	rows, err := m.Pool.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []*Deletion{}
+	for rows.Next() {
+		d, err := scanDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// Advance records that the deletion has to run step next.
+func (m *DeletionModel) Advance(videoID uuid.UUID, step string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := "UPDATE video_deletions SET step = $1, last_error = NULL, updated_at = CURRENT_TIMESTAMP WHERE video_id = $2"
+	_, err := m.Pool.Exec(ctx, query, step, videoID)
+	return err
+}
+
+// Fail records why the current step of the deletion failed.
+func (m *DeletionModel) Fail(videoID uuid.UUID, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := "UPDATE video_deletions SET last_error = $1, updated_at = CURRENT_TIMESTAMP WHERE video_id = $2"
+	_, err := m.Pool.Exec(ctx, query, cause.Error(), videoID)
+	return err
+}
+
+// Finish deletes the rows of the video and marks its deletion done, together
+// with msgs, the outbox messages announcing it. A deletion that is done
+// already is left alone, so the messages are only recorded once.
+func (m *DeletionModel) Finish(videoID uuid.UUID, msgs ...*OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE video_deletions SET step = $1, last_error = NULL, done_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE video_id = $2 AND done_at IS NULL
+	`
+	res, err := tx.Exec(ctx, query, DeleteDone, videoID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return nil
+	}
+	if err := deleteVideo(ctx, tx, videoID); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err := insertOutbox(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func scanDeletion(row pgx.Row) (*Deletion, error) {
+	var d Deletion
+	err := row.Scan(&d.VideoId, &d.Step, &d.Attempts, &d.LastError, &d.CreatedAt, &d.UpdatedAt, &d.DoneAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	JobQueued      = "queued"
+	JobDownloading = "downloading"
+	JobTranscoding = "transcoding"
+	JobSubtitling  = "subtitling"
+	JobUpscaling   = "upscaling"
+	JobDone        = "done"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+)
+
+var jobStatuses = map[string]bool{
+	JobQueued:      true,
+	JobDownloading: true,
+	JobTranscoding: true,
+	JobSubtitling:  true,
+	JobUpscaling:   true,
+	JobDone:        true,
+	JobFailed:      true,
+	JobCancelled:   true,
+}
+
+// Finished reports whether status is final and the job will not run again.
+func Finished(status string) bool {
+	return status == JobDone || status == JobFailed || status == JobCancelled
+}
+
+type JobModel struct {
+	Pool *pgxpool.Pool
+}
+
+type Job struct {
+	JobId         uuid.UUID  `json:"job_id"`
+	VideoId       uuid.UUID  `json:"video_id"`
+	Status        string     `json:"status"`
+	Error         *string    `json:"error,omitempty"`
+	Attempts      int        `json:"attempts"`
+	QueuedAt      *time.Time `json:"queued_at"`
+	DownloadingAt *time.Time `json:"downloading_at"`
+	TranscodingAt *time.Time `json:"transcoding_at"`
+	SubtitlingAt  *time.Time `json:"subtitling_at"`
+	UpscalingAt   *time.Time `json:"upscaling_at"`
+	DoneAt        *time.Time `json:"done_at"`
+	FailedAt      *time.Time `json:"failed_at"`
+	CancelledAt   *time.Time `json:"cancelled_at"`
+	CreatedAt     *time.Time `json:"created_at"`
+	UpdatedAt     *time.Time `json:"update_at"`
+}
+
+const jobColumns = `job_id, video_id, status, error, attempts, queued_at, downloading_at, transcoding_at,
+	subtitling_at, upscaling_at, done_at, failed_at, cancelled_at, created_at, updated_at`
+
+// Enqueue records job together with msgs, the outbox messages that hand it
+// to the workers and announce it, in one transaction. A video that is new is
+// recorded with them as uploaded; pass nil when the video exists already.
+func (m *JobModel) Enqueue(video *Video, job *Job, msgs ...*OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if video != nil {
+		query := "INSERT INTO videos(video_id, name, status, visibility) VALUES($1, $2, $3, $4)"
+		if _, err := tx.Exec(ctx, query, video.VideoId, video.Name, VideoUploaded, video.Visibility); err != nil {
+			return err
+		}
+	}
+	query := "INSERT INTO jobs(job_id, video_id, status) VALUES($1, $2, $3)"
+	if _, err := tx.Exec(ctx, query, job.JobId, job.VideoId, JobQueued); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err := insertOutbox(ctx, tx, msg); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (m *JobModel) SetStatus(id uuid.UUID, status string) error {
+	if !jobStatuses[status] {
+		return fmt.Errorf("invalid job status: %s", status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	// A cancelled job stays cancelled even if its worker is still winding down.
+	query := fmt.Sprintf(`UPDATE jobs SET status = $1, "%s_at" = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE job_id = $2 AND status <> $3`, status)
+	if status == JobDone {
+		query = `UPDATE jobs SET status = $1, error = NULL, done_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE job_id = $2 AND status <> $3`
+	}
+	res, err := m.Pool.Exec(ctx, query, status, id, JobCancelled)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("no rows updated for job_id %s", id)
+	}
+	return nil
+}
+
+func (m *JobModel) Fail(id uuid.UUID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `UPDATE jobs SET status = $1, error = $2, failed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE job_id = $3 AND status <> $4`
+	_, err := m.Pool.Exec(ctx, query, JobFailed, jobErr.Error(), id, JobCancelled)
+	return err
+}
+
+// Retry puts a failed job back in the queue, keeping the error of the last
+// attempt until the next one finishes.
+func (m *JobModel) Retry(id uuid.UUID, attempt int, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `UPDATE jobs SET status = $1, error = $2, attempts = $3, queued_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE job_id = $4 AND status <> $5`
+	_, err := m.Pool.Exec(ctx, query, JobQueued, jobErr.Error(), attempt, id, JobCancelled)
+	return err
+}
+
+func (m *JobModel) GetByID(id uuid.UUID) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + jobColumns + " FROM jobs WHERE job_id = $1"
+	job, err := scanJob(m.Pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (m *JobModel) GetByVideoID(videoId uuid.UUID) ([]*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + jobColumns + " FROM jobs WHERE video_id = $1 ORDER BY created_at DESC"
+	rows, err := m.Pool.Query(ctx, query, videoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.JobId,
+		&job.VideoId,
+		&job.Status,
+		&job.Error,
+		&job.Attempts,
+		&job.QueuedAt,
+		&job.DownloadingAt,
+		&job.TranscodingAt,
+		&job.SubtitlingAt,
+		&job.UpscalingAt,
+		&job.DoneAt,
+		&job.FailedAt,
+		&job.CancelledAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type MetadataModel struct {
+	Pool *pgxpool.Pool
+}
+
+type MediaInfo struct {
+	FormatName      string            `json:"format_name"`
+	Duration        float64           `json:"duration"`
+	Size            int64             `json:"size"`
+	BitRate         int64             `json:"bit_rate"`
+	Width           int               `json:"width"`
+	Height          int               `json:"height"`
+	VideoCodec      string            `json:"video_codec"`
+	VideoProfile    string            `json:"video_profile"`
+	VideoBitRate    int64             `json:"video_bit_rate"`
+	FrameRate       float64           `json:"frame_rate"`
+	PixelFormat     string            `json:"pixel_format"`
+	ColorPrimaries  string            `json:"color_primaries"`
+	ColorTransfer   string            `json:"color_transfer"`
+	ColorSpace      string            `json:"color_space"`
+	Rotation        int               `json:"rotation"`
+	AudioCodec      string            `json:"audio_codec"`
+	AudioChannels   int               `json:"audio_channels"`
+	AudioLayout     string            `json:"audio_channel_layout"`
+	AudioSampleRate int               `json:"audio_sample_rate"`
+	AudioBitRate    int64             `json:"audio_bit_rate"`
+	Tags            map[string]string `json:"tags"`
+	CreationTime    *time.Time        `json:"creation_time"`
+}
+
+const metadataColumns = `format_name, duration, size, bit_rate, width, height, video_codec, video_profile,
+	video_bit_rate, frame_rate, pixel_format, color_primaries, color_transfer, color_space, rotation,
+	audio_codec, audio_channels, audio_channel_layout, audio_sample_rate, audio_bit_rate, tags, creation_time`
+
+func (m *MetadataModel) Upsert(videoId uuid.UUID, info *MediaInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	tags := info.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	query := `
+		INSERT INTO video_metadata(video_id, ` + metadataColumns + `)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+		ON CONFLICT (video_id) DO UPDATE SET
+			format_name = EXCLUDED.format_name,
+			duration = EXCLUDED.duration,
+			size = EXCLUDED.size,
+			bit_rate = EXCLUDED.bit_rate,
+			width = EXCLUDED.width,
+			height = EXCLUDED.height,
+			video_codec = EXCLUDED.video_codec,
+			video_profile = EXCLUDED.video_profile,
+			video_bit_rate = EXCLUDED.video_bit_rate,
+			frame_rate = EXCLUDED.frame_rate,
+			pixel_format = EXCLUDED.pixel_format,
+			color_primaries = EXCLUDED.color_primaries,
+			color_transfer = EXCLUDED.color_transfer,
+			color_space = EXCLUDED.color_space,
+			rotation = EXCLUDED.rotation,
+			audio_codec = EXCLUDED.audio_codec,
+			audio_channels = EXCLUDED.audio_channels,
+			audio_channel_layout = EXCLUDED.audio_channel_layout,
+			audio_sample_rate = EXCLUDED.audio_sample_rate,
+			audio_bit_rate = EXCLUDED.audio_bit_rate,
+			tags = EXCLUDED.tags,
+			creation_time = EXCLUDED.creation_time,
+			updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := m.Pool.Exec(ctx, query,
+		videoId,
+		info.FormatName,
+		info.Duration,
+		info.Size,
+		info.BitRate,
+		info.Width,
+		info.Height,
+		info.VideoCodec,
+		info.VideoProfile,
+		info.VideoBitRate,
+		info.FrameRate,
+		info.PixelFormat,
+		info.ColorPrimaries,
+		info.ColorTransfer,
+		info.ColorSpace,
+		info.Rotation,
+		info.AudioCodec,
+		info.AudioChannels,
+		info.AudioLayout,
+		info.AudioSampleRate,
+		info.AudioBitRate,
+		tags,
+		info.CreationTime,
+	)
+	return err
+}
+
+func (m *MetadataModel) GetByVideoID(videoId uuid.UUID) (*MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + metadataColumns + " FROM video_metadata WHERE video_id = $1"
+	var info MediaInfo
+	err := m.Pool.QueryRow(ctx, query, videoId).Scan(
+		&info.FormatName,
+		&info.Duration,
+		&info.Size,
+		&info.BitRate,
+		&info.Width,
+		&info.Height,
+		&info.VideoCodec,
+		&info.VideoProfile,
+		&info.VideoBitRate,
+		&info.FrameRate,
+		&info.PixelFormat,
+		&info.ColorPrimaries,
+		&info.ColorTransfer,
+		&info.ColorSpace,
+		&info.Rotation,
+		&info.AudioCodec,
+		&info.AudioChannels,
+		&info.AudioLayout,
+		&info.AudioSampleRate,
+		&info.AudioBitRate,
+		&info.Tags,
+		&info.CreationTime,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,15 @@
+package database
+
+import (
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+)
+
+// NewMigrator returns a golang-migrate instance for the files in dir. It is
+// the library behind the migrate CLI the Makefile uses, so both keep the same
+// schema_migrations table and can be used on the same database.
+func NewMigrator(conf *config.Config, dir string) (*migrate.Migrate, error) {
+	return migrate.New("file://"+dir, URL(conf))
+}
@@ -1,13 +1,25 @@
-package database
-
-import "github.com/jackc/pgx/v5/pgxpool"
-
-type Models struct {
-	Videos VideoModel
-}
-
-func NewModel(pool *pgxpool.Pool) Models {
-	return Models{
-		Videos: VideoModel{Pool: pool},
-	}
-}
+package database
+
+import "github.com/jackc/pgx/v5/pgxpool"
+
+type Models struct {
+	Videos     VideoModel
+	Jobs       JobModel
+	Metadata   MetadataModel
+	Renditions RenditionModel
+	Outbox     OutboxModel
+	Steps      StepModel
+	Deletions  DeletionModel
+}
+
+func NewModel(pool *pgxpool.Pool) Models {
+	return Models{
+		Videos:     VideoModel{Pool: pool},
+		Jobs:       JobModel{Pool: pool},
+		Metadata:   MetadataModel{Pool: pool},
+		Renditions: RenditionModel{Pool: pool},
+		Outbox:     OutboxModel{Pool: pool},
+		Steps:      StepModel{Pool: pool},
+		Deletions:  DeletionModel{Pool: pool},
+	}
+}
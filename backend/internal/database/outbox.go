@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OutboxModel holds broker messages written in the same transaction as the
+// rows they announce, until a relay has published them.
+type OutboxModel struct {
+	Pool *pgxpool.Pool
+}
+
+type OutboxMessage struct {
+	OutboxId int64
+	Topic    string
+	Key      string
+	Payload  []byte
+	Headers  map[string]string
+	Attempts int
+}
+
+// Add records msg on its own, for messages that don't go with other writes.
+func (m *OutboxModel) Add(msg *OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := insertOutbox(ctx, tx, msg); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func insertOutbox(ctx context.Context, tx pgx.Tx, msg *OutboxMessage) error {
+	var headers []byte
+	if len(msg.Headers) > 0 {
+		var err error
+		if headers, err = json.Marshal(msg.Headers); err != nil {
+			return err
+		}
+	}
+	query := "INSERT INTO outbox(topic, message_key, payload, headers) VALUES($1, $2, $3, $4) RETURNING outbox_id"
+	return tx.QueryRow(ctx, query, msg.Topic, msg.Key, msg.Payload, headers).Scan(&msg.OutboxId)
+}
+
+// outboxLock is the advisory lock that lets one relay at a time dispatch.
+const outboxLock = 0x6f7574626f78
+
+// Dispatch passes up to limit unsent messages to send in one batch, oldest
+// first, and marks them as sent if it went through. A failure is recorded on
+// every message of the batch, which is sent again as a whole. Relays take
+// turns, so messages are always sent in the order they were written, which
+// keeps the events of a video in order; a relay that dies between sending and
+// marking leaves its batch to be sent again.
+func (m *OutboxModel) Dispatch(ctx context.Context, limit int, send func([]*OutboxMessage) error) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tx, err := m.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", outboxLock); err != nil {
+		return 0, err
+	}
+	query := `
+		SELECT outbox_id, topic, message_key, payload, headers, attempts
+		FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY outbox_id
+		LIMIT $1
+	`
+	rows, err := tx.Query(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	var pending []*OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		var headers []byte
+		if err := rows.Scan(&msg.OutboxId, &msg.Topic, &msg.Key, &msg.Payload, &headers, &msg.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if headers != nil {
+			if err := json.Unmarshal(headers, &msg.Headers); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("outbox message %d: %w", msg.OutboxId, err)
+			}
+		}
+		pending = append(pending, &msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	ids := make([]int64, 0, len(pending))
+	for _, msg := range pending {
+		ids = append(ids, msg.OutboxId)
+	}
+	if sendErr := send(pending); sendErr != nil {
+		query := "UPDATE outbox SET attempts = attempts + 1, last_error = $1 WHERE outbox_id = ANY($2)"
+		if _, err := tx.Exec(ctx, query, sendErr.Error(), ids); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, err
+		}
+		return 0, sendErr
+	}
+	query = "UPDATE outbox SET sent_at = CURRENT_TIMESTAMP, last_error = NULL WHERE outbox_id = ANY($1)"
+	if _, err := tx.Exec(ctx, query, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// DeleteSent removes messages that were sent more than age ago.
+func (m *OutboxModel) DeleteSent(age time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := "DELETE FROM outbox WHERE sent_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'"
+	res, err := m.Pool.Exec(ctx, query, int64(age.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
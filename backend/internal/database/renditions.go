@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	RenditionTranscode = "transcode"
+	RenditionUpscale   = "upscale"
+	// RenditionOriginal marks the rendition kept at the source height.
+	RenditionOriginal = "original"
+)
+
+const (
+	RenditionPending = "pending"
+	RenditionReady   = "ready"
+	RenditionFailed  = "failed"
+)
+
+type RenditionModel struct {
+	Pool *pgxpool.Pool
+}
+
+type Rendition struct {
+	VideoId uuid.UUID `json:"-"`
+	Height  int       `json:"height"`
+	Width   int       `json:"width"`
+	Codec   string    `json:"codec"`
+	Bitrate int64     `json:"bitrate"`
+	Size    int64     `json:"size"`
+	S3Key   string    `json:"key"`
+	// URL is filled in by the API, presigned for private videos.
+	URL       string     `json:"url,omitempty"`
+	Source    string     `json:"source"`
+	Status    string     `json:"status"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"update_at"`
+}
+
+const renditionColumns = `video_id, height, width, codec, bitrate, size_bytes, s3_key, source, status, created_at, updated_at`
+
+// Upsert records a rendition, replacing the row left by an earlier attempt for
+// the same height.
+func (m *RenditionModel) Upsert(r *Rendition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `
+		INSERT INTO renditions(video_id, height, width, codec, bitrate, size_bytes, s3_key, source, status)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (video_id, height) DO UPDATE SET
+			width = EXCLUDED.width,
+			codec = EXCLUDED.codec,
+			bitrate = EXCLUDED.bitrate,
+			size_bytes = EXCLUDED.size_bytes,
+			s3_key = EXCLUDED.s3_key,
+			source = EXCLUDED.source,
+			status = EXCLUDED.status,
+			updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := m.Pool.Exec(ctx, query, r.VideoId, r.Height, r.Width, r.Codec, r.Bitrate, r.Size, r.S3Key, r.Source, r.Status)
+	return err
+}
+
+// Pending records a rendition that is about to be encoded. A rendition that is
+// ready already stays ready, and keeps being served, until it is replaced.
+func (m *RenditionModel) Pending(r *Rendition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `
+		INSERT INTO renditions(video_id, height, s3_key, source, status)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (video_id, height) DO UPDATE SET
+			s3_key = EXCLUDED.s3_key,
+			source = EXCLUDED.source,
+			status = EXCLUDED.status,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE renditions.status <> $6
+	`
+	_, err := m.Pool.Exec(ctx, query, r.VideoId, r.Height, r.S3Key, r.Source, RenditionPending, RenditionReady)
+	return err
+}
+
+func (m *RenditionModel) SetStatus(videoId uuid.UUID, height int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `UPDATE renditions SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE video_id = $2 AND height = $3`
+	res, err := m.Pool.Exec(ctx, query, status, videoId, height)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("no rows updated for rendition %s/%d", videoId, height)
+	}
+	return nil
+}
+
+func (m *RenditionModel) GetByVideoID(videoId uuid.UUID) ([]*Rendition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + renditionColumns + " FROM renditions WHERE video_id = $1 ORDER BY height"
+	return queryRenditions(ctx, m.Pool, query, videoId)
+}
+
+// GetReady returns only the renditions that were uploaded successfully.
+func (m *RenditionModel) GetReady(videoId uuid.UUID) ([]*Rendition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + renditionColumns + " FROM renditions WHERE video_id = $1 AND status = $2 ORDER BY height"
+	return queryRenditions(ctx, m.Pool, query, videoId, RenditionReady)
+}
+
+// GetReadyByHeight returns the ready rendition of a video at height, or nil if
+// there is none.
+func (m *RenditionModel) GetReadyByHeight(videoId uuid.UUID, height int) (*Rendition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + renditionColumns + " FROM renditions WHERE video_id = $1 AND height = $2 AND status = $3"
+	r, err := scanRendition(m.Pool.QueryRow(ctx, query, videoId, height, RenditionReady))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return r, err
+}
+
+// Delete removes the row of a rendition.
+func (m *RenditionModel) Delete(videoId uuid.UUID, height int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := "DELETE FROM renditions WHERE video_id = $1 AND height = $2"
+	_, err := m.Pool.Exec(ctx, query, videoId, height)
+	return err
+}
+
+func queryRenditions(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]*Rendition, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []*Rendition{}
+	for rows.Next() {
+		r, err := scanRendition(rows)
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func scanRendition(row pgx.Row) (*Rendition, error) {
+	var r Rendition
+	err := row.Scan(
+		&r.VideoId,
+		&r.Height,
+		&r.Width,
+		&r.Codec,
+		&r.Bitrate,
+		&r.Size,
+		&r.S3Key,
+		&r.Source,
+		&r.Status,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StepModel records the steps of a job that are finished, with whatever a
+// later step needs from them, so a redelivered job can skip them.
+type StepModel struct {
+	Pool *pgxpool.Pool
+}
+
+// Completed returns the data of every finished step of a job by step name.
+func (m *StepModel) Completed(jobID uuid.UUID) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	rows, err := m.Pool.Query(ctx, "SELECT step, data FROM job_steps WHERE job_id = $1", jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	steps := map[string][]byte{}
+	for rows.Next() {
+		var step string
+		var data []byte
+		if err := rows.Scan(&step, &data); err != nil {
+			return nil, err
+		}
+		steps[step] = data
+	}
+	return steps, rows.Err()
+}
+
+func (m *StepModel) Complete(jobID uuid.UUID, step string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	query := `
+		INSERT INTO job_steps(job_id, step, data) VALUES($1, $2, $3)
+		ON CONFLICT (job_id, step) DO UPDATE SET
+			data = EXCLUDED.data,
+			done_at = CURRENT_TIMESTAMP
+	`
+	_, err := m.Pool.Exec(ctx, query, jobID, step, data)
+	return err
+}
@@ -0,0 +1,314 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxRedirects = 5
+	// idleTimeout and timeout are the defaults of New for how long the body
+	// may send nothing, and how long the whole download may take.
+	idleTimeout = time.Minute
+	timeout     = 2 * time.Hour
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid url")
+	ErrForbiddenAddress = errors.New("address is not allowed")
+	ErrTooLarge         = errors.New("remote file is too large")
+	ErrUnsupportedType  = errors.New("unsupported content type")
+	ErrBadStatus        = errors.New("unexpected response status")
+	ErrStalled          = errors.New("remote stopped sending data")
+	ErrTimeout          = errors.New("remote file took too long to download")
+)
+
+var contentTypeExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/x-msvideo":  ".avi",
+	"video/x-matroska": ".mkv",
+	"video/webm":       ".webm",
+	"video/mp2t":       ".ts",
+	"video/x-m4v":      ".m4v",
+}
+
+var extPattern = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+type Importer struct {
+	Client  *http.Client
+	MaxSize int64
+	// IdleTimeout stops a download whose body sent nothing for that long,
+	// and Timeout one that is still running after it. Zero means no limit.
+	IdleTimeout time.Duration
+	Timeout     time.Duration
+}
+
+type Remote struct {
+	Body        *LimitedBody
+	Size        int64
+	ContentType string
+	Ext         string
+	Name        string
+}
+
+// New returns an importer whose client refuses to connect to loopback,
+// private, link-local and other non-public addresses. The check runs on the
+// resolved address of every connection, so redirects and DNS rebinding can't
+// be used to reach internal services.
+func New(maxSize int64) *Importer {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !IsPublic(addr) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &Importer{
+		Client: &http.Client{
+			Transport:     transport,
+			CheckRedirect: checkRedirect,
+		},
+		MaxSize:     maxSize,
+		IdleTimeout: idleTimeout,
+		Timeout:     timeout,
+	}
+}
+
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if err := validateURL(req.URL); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+	return nil
+}
+
+// Open starts downloading rawURL. The returned body stops with ErrTooLarge
+// once more than MaxSize bytes have been read, which covers servers that send
+// no or a wrong Content-Length, and with ErrStalled or ErrTimeout once the
+// download exceeds IdleTimeout or Timeout.
+func (im *Importer) Open(ctx context.Context, rawURL string) (*Remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := validateURL(u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	deadline := deadlineTimer(im.Timeout, func() { cancel(ErrTimeout) })
+	stop := func() {
+		if deadline != nil {
+			deadline.Stop()
+		}
+		cancel(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	resp, err := im.Client.Do(req)
+	if err != nil {
+		stop()
+		if cause := context.Cause(ctx); errors.Is(cause, ErrTimeout) {
+			err = cause
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", u.Redacted(), err)
+	}
+
+	remote, err := im.inspect(resp)
+	if err != nil {
+		resp.Body.Close()
+		stop()
+		return nil, err
+	}
+	remote.Body.ctx = ctx
+	remote.Body.stop = stop
+	remote.Body.idle = deadlineTimer(im.IdleTimeout, func() { cancel(ErrStalled) })
+	remote.Body.idleTimeout = im.IdleTimeout
+	return remote, nil
+}
+
+// deadlineTimer calls f after d. It returns nil if d is zero, for no limit.
+func deadlineTimer(d time.Duration, f func()) *time.Timer {
+	if d <= 0 {
+		return nil
+	}
+	return time.AfterFunc(d, f)
+}
+
+func (im *Importer) inspect(resp *http.Response) (*Remote, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+	if resp.ContentLength > im.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = "application/octet-stream"
+	}
+	if !strings.HasPrefix(contentType, "video/") && !genericContentTypes[contentType] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+	finalPath := resp.Request.URL.Path
+	ext, ok := contentTypeExtensions[contentType]
+	if !ok {
+		ext = strings.ToLower(path.Ext(finalPath))
+		if !extPattern.MatchString(ext) {
+			ext = ""
+		}
+	}
+
+	return &Remote{
+		Body:        &LimitedBody{body: resp.Body, remaining: im.MaxSize},
+		Size:        resp.ContentLength,
+		ContentType: contentType,
+		Ext:         ext,
+		Name:        strings.TrimSuffix(path.Base(finalPath), path.Ext(finalPath)),
+	}, nil
+}
+
+// LimitedBody is the body of a download. It stops the download once it read
+// more than its limit, sent nothing for the idle timeout or ran past the
+// overall timeout of its Importer.
+type LimitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	exceeded  bool
+
+	ctx         context.Context
+	stop        func()
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+func (b *LimitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var probe [1]byte
+		if n, _ := b.body.Read(probe[:]); n > 0 {
+			b.exceeded = true
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	if n > 0 && b.idle != nil {
+		b.idle.Reset(b.idleTimeout)
+	}
+	if err != nil && err != io.EOF {
+		if cause := b.Interrupted(); cause != nil {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *LimitedBody) Exceeded() bool {
+	return b.exceeded
+}
+
+// Interrupted returns ErrStalled or ErrTimeout if the download was stopped
+// for taking too long, and nil otherwise.
+func (b *LimitedBody) Interrupted() error {
+	if b.ctx == nil {
+		return nil
+	}
+	if cause := context.Cause(b.ctx); errors.Is(cause, ErrStalled) || errors.Is(cause, ErrTimeout) {
+		return cause
+	}
+	return nil
+}
+
+func (b *LimitedBody) Close() error {
+	if b.idle != nil {
+		b.idle.Stop()
+	}
+	if b.stop != nil {
+		b.stop()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,160 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// boundedConsumer reads a topic one partition at a time, from the offsets
+// committed by a group up to the end the partition had when the consumer was
+// opened. It reads at explicit offsets instead of joining the group, so it
+// doesn't wait for an assignment, and commits the offsets of the group itself.
+type boundedConsumer struct {
+	client  *kafka.Client
+	brokers []string
+	topic   string
+	group   string
+	ranges  []offsetRange
+	reader  *kafka.Reader
+}
+
+// offsetRange is what is left to read of a partition: from next up to, but
+// not including, end.
+type offsetRange struct {
+	partition int
+	next, end int64
+}
+
+func (k *Kafka) subscribeToEnd(ctx context.Context, topic, group string) (Consumer, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", k.Brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka broker: %w", err)
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions of %s: %w", topic, err)
+	}
+
+	client := &kafka.Client{Addr: kafka.TCP(k.Brokers...)}
+	ids := make([]int, 0, len(partitions))
+	requests := make([]kafka.OffsetRequest, 0, 2*len(partitions))
+	for _, p := range partitions {
+		ids = append(ids, p.ID)
+		requests = append(requests, kafka.FirstOffsetOf(p.ID), kafka.LastOffsetOf(p.ID))
+	}
+	offsets, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of %s: %w", topic, err)
+	}
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  map[string][]int{topic: ids},
+	})
+	if err == nil {
+		err = committed.Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, err)
+	}
+	// A partition the group never committed on has -1.
+	next := map[int]int64{}
+	for _, p := range committed.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", group, p.Error)
+		}
+		next[p.Partition] = p.CommittedOffset
+	}
+
+	c := &boundedConsumer{client: client, brokers: k.Brokers, topic: topic, group: group}
+	for _, p := range offsets.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list offsets of %s: %w", topic, p.Error)
+		}
+		start := max(next[p.Partition], p.FirstOffset)
+		if start < p.LastOffset {
+			c.ranges = append(c.ranges, offsetRange{partition: p.Partition, next: start, end: p.LastOffset})
+		}
+	}
+	return c, nil
+}
+
+func (c *boundedConsumer) Fetch(ctx context.Context) (*Delivery, error) {
+	for len(c.ranges) > 0 && c.ranges[0].next >= c.ranges[0].end {
+		c.closeReader()
+		c.ranges = c.ranges[1:]
+	}
+	if len(c.ranges) == 0 {
+		return nil, ErrEndOfTopic
+	}
+	r := &c.ranges[0]
+	if c.reader == nil {
+		c.reader = kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   c.brokers,
+			Topic:     c.topic,
+			Partition: r.partition,
+			MinBytes:  1,
+			MaxBytes:  10e6,
+		})
+		if err := c.reader.SetOffset(r.next); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.next = msg.Offset + 1
+	return &Delivery{
+		Message: fromKafka(msg),
+		ack: func(ctx context.Context) error {
+			return c.commit(ctx, msg.Partition, msg.Offset+1)
+		},
+		// Read again by the next Fetch; nothing after it was committed.
+		nack: func(context.Context) error {
+			if c.reader == nil || len(c.ranges) == 0 || c.ranges[0].partition != msg.Partition {
+				return nil
+			}
+			c.ranges[0].next = msg.Offset
+			return c.reader.SetOffset(msg.Offset)
+		},
+	}, nil
+}
+
+func (c *boundedConsumer) commit(ctx context.Context, partition int, offset int64) error {
+	resp, err := c.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID: c.group,
+		// Offsets of a group without members are committed outside of any
+		// generation.
+		GenerationID: -1,
+		Topics: map[string][]kafka.OffsetCommit{
+			c.topic: {{Partition: partition, Offset: offset}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range resp.Topics[c.topic] {
+		if p.Error != nil {
+			return p.Error
+		}
+	}
+	return nil
+}
+
+func (c *boundedConsumer) closeReader() {
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+}
+
+func (c *boundedConsumer) Close() error {
+	c.closeReader()
+	c.ranges = nil
+	return nil
+}
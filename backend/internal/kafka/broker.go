@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+)
+
+const (
+	DriverKafka  = "kafka"
+	DriverMemory = "memory"
+)
+
+var (
+	ErrClosed = errors.New("broker is closed")
+	// ErrEndOfTopic is returned by a consumer that reads a topic up to an end
+	// once it got there.
+	ErrEndOfTopic = errors.New("end of topic")
+)
+
+// Message is what travels through the broker, independent of the transport.
+type Message struct {
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+// Delivery is a message handed to a consumer. It stays pending until it is
+// acknowledged; a nacked delivery is delivered again.
+type Delivery struct {
+	Message
+	ack  func(ctx context.Context) error
+	nack func(ctx context.Context) error
+}
+
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.ack(ctx)
+}
+
+func (d *Delivery) Nack(ctx context.Context) error {
+	return d.nack(ctx)
+}
+
+type Publisher interface {
+	// Publish sends msgs in order, in one batch where the transport allows
+	// it. Messages with the same key reach the same partition, so they are
+	// consumed in the order they were published.
+	Publish(ctx context.Context, msgs ...Message) error
+}
+
+type Consumer interface {
+	// Fetch blocks until a message is available or ctx is done.
+	Fetch(ctx context.Context) (*Delivery, error)
+	// Close stops the consumer. Deliveries that weren't acknowledged are
+	// delivered again to the other consumers of the group.
+	Close() error
+}
+
+// endSubscriber is implemented by brokers that can read a topic from the
+// offsets of a group up to the end the topic has now, without waiting to be
+// assigned its partitions. Their consumers return ErrEndOfTopic once they
+// got there.
+type endSubscriber interface {
+	subscribeToEnd(ctx context.Context, topic, group string) (Consumer, error)
+}
+
+type Broker interface {
+	Publisher
+	// Subscribe joins group on topic. Every group gets every message once,
+	// shared between the consumers in it.
+	Subscribe(topic, group string) (Consumer, error)
+	Close() error
+}
+
+// New connects to the broker selected by conf.Kafka.Driver.
+func New(conf *config.Config) (Broker, error) {
+	switch conf.Kafka.Driver {
+	case DriverKafka, "":
+		return NewKafka(conf)
+	case DriverMemory:
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown broker driver %q", conf.Kafka.Driver)
+}
+
+// SubscribeJobs joins the worker group of every job topic and returns the
+// consumers by topic. Each topic has its own group so that a rebalance in one
+// tier doesn't pause the others.
+func SubscribeJobs(b Broker) (map[string]Consumer, error) {
+	consumers := map[string]Consumer{}
+	for _, priority := range Priorities {
+		topic := JobTopic(priority)
+		consumer, err := b.Subscribe(topic, GroupWorkers+"-"+priority)
+		if err != nil {
+			for _, c := range consumers {
+				c.Close()
+			}
+			return nil, err
+		}
+		consumers[topic] = consumer
+	}
+	return consumers, nil
+}
@@ -1,78 +1,174 @@
 package broker
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ksamf/video-upscaling/backend/internal/config"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
 	"github.com/segmentio/kafka-go"
 )
 
-type KafkaClients struct {
+const (
+	// TopicJobs is the prefix of the per-priority job topics, see JobTopic.
+	TopicJobs      = "video-job"
+	TopicJobsDLQ   = "video-job-dlq"
+	GroupWorkers   = "video-processor"
+	GroupDLQReplay = "video-job-dlq-replay"
+)
+
+// Kafka is the Broker used in production.
+type Kafka struct {
+	Brokers []string
+	// Writer publishes to the topic named on each message.
 	Writer *kafka.Writer
-	Reader *kafka.Reader
 }
+
 type VideoJob struct {
-	VideoID        uuid.UUID `json:"video_id"`
-	FileName       string    `json:"file_name"`
-	FileExt        string    `json:"file_ext"`
-	BaseURL        string    `json:"base_url"`
-	Upscale        bool      `json:"upscale"`
-	RealisticVideo bool      `json:"realistic_video"`
+	JobID    uuid.UUID `json:"job_id"`
+	VideoID  uuid.UUID `json:"video_id"`
+	FileName string    `json:"file_name"`
+	FileExt  string    `json:"file_ext"`
+	// SourceKey overrides the uploaded <id>/tmp<ext> object as the input. It is
+	// set when reprocessing from an existing rendition, which is kept afterwards.
+	SourceKey      string `json:"source_key,omitempty"`
+	BaseURL        string `json:"base_url"`
+	Upscale        bool   `json:"upscale"`
+	RealisticVideo bool   `json:"realistic_video"`
+	// Priority picks the job topic, see JobTopic.
+	Priority string `json:"priority,omitempty"`
 }
 
-func New(conf *config.Config) *KafkaClients {
-	topic := "video-job"
-	group := "video-processor"
+func NewKafka(conf *config.Config) (*Kafka, error) {
 	broker := fmt.Sprintf("%s:%d", conf.Kafka.Host, conf.Kafka.Port)
 
 	conn, err := kafka.Dial("tcp", broker)
 	if err != nil {
-		log.Fatalf("failed to connect to Kafka broker: %v", err)
+		return nil, fmt.Errorf("failed to connect to Kafka broker: %w", err)
 	}
 	defer conn.Close()
 	partitions, err := conn.ReadPartitions()
 	if err != nil {
-		log.Fatalf("failed to read partitions: %v", err)
+		return nil, fmt.Errorf("failed to read partitions: %w", err)
 	}
-	topicExists := false
+	existing := map[string]bool{}
 	for _, p := range partitions {
-		if p.Topic == topic {
-			topicExists = true
-			break
-		}
+		existing[p.Topic] = true
 	}
-	if !topicExists {
+	topics := []string{TopicJobsDLQ, events.Topic}
+	for _, priority := range Priorities {
+		topics = append(topics, JobTopic(priority))
+	}
+	for _, topic := range topics {
+		if existing[topic] {
+			continue
+		}
 		err := conn.CreateTopics(kafka.TopicConfig{
 			Topic:             topic,
 			NumPartitions:     1,
 			ReplicationFactor: 1,
 		})
 		if err != nil {
-			log.Fatalf("failed to create topic %s: %v", topic, err)
+			return nil, fmt.Errorf("failed to create topic %s: %w", topic, err)
 		}
 		log.Println("Created Kafka topic:", topic)
 	}
 
+	// The outbox relay already hands over its messages in batches, so the
+	// writer doesn't wait for more to fill one.
 	writer := &kafka.Writer{
 		Addr:         kafka.TCP(broker),
-		Topic:        topic,
-		Balancer:     &kafka.LeastBytes{},
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 5 * time.Millisecond,
 		RequiredAcks: kafka.RequireAll,
 	}
 
+	log.Println("Kafka connected to:", broker, "topics:", topics)
+	return &Kafka{
+		Brokers: []string{broker},
+		Writer:  writer,
+	}, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, msgs ...Message) error {
+	out := make([]kafka.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		m := kafka.Message{
+			Topic: msg.Topic,
+			Key:   []byte(msg.Key),
+			Value: msg.Value,
+		}
+		for key, value := range msg.Headers {
+			m.Headers = append(m.Headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+		out = append(out, m)
+	}
+	return k.Writer.WriteMessages(ctx, out...)
+}
+
+// Subscribe opens a reader in group. Only workers should subscribe: a reader
+// that joins a group gets partitions assigned whether it fetches or not.
+func (k *Kafka) Subscribe(topic, group string) (Consumer, error) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{broker},
+		Brokers:  k.Brokers,
 		GroupID:  group,
 		Topic:    topic,
 		MinBytes: 1,
 		MaxBytes: 10e6,
 	})
+	return &kafkaConsumer{reader: reader, offsets: newOffsetTracker()}, nil
+}
+
+func (k *Kafka) Close() error {
+	return k.Writer.Close()
+}
+
+// kafkaConsumer acknowledges by committing offsets. Kafka can't redeliver a
+// single message, so a nack leaves its offset uncommitted and holds back every
+// later one of the partition: the message comes back, together with the ones
+// after it, once the partition is assigned again.
+type kafkaConsumer struct {
+	reader  *kafka.Reader
+	offsets *offsetTracker
+}
+
+func (c *kafkaConsumer) Fetch(ctx context.Context) (*Delivery, error) {
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracked := c.offsets.track(msg)
+	return &Delivery{
+		Message: fromKafka(msg),
+		ack: func(ctx context.Context) error {
+			tp := c.offsets.finish(tracked, true)
+			return c.offsets.commit(tp, func(msg kafka.Message) error {
+				return c.reader.CommitMessages(ctx, msg)
+			})
+		},
+		nack: func(context.Context) error {
+			c.offsets.finish(tracked, false)
+			return nil
+		},
+	}, nil
+}
 
-	log.Println("Kafka connected to:", broker, "topic:", topic)
-	return &KafkaClients{
-		Writer: writer,
-		Reader: reader,
+func (c *kafkaConsumer) Close() error {
+	return c.reader.Close()
+}
+
+func fromKafka(msg kafka.Message) Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return Message{
+		Topic:   msg.Topic,
+		Key:     string(msg.Key),
+		Value:   msg.Value,
+		Headers: headers,
 	}
 }
@@ -0,0 +1,22 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+)
+
+// EventMessage builds the message that carries e to the events topic. Events
+// are keyed by video so the ones of a video stay in order.
+func EventMessage(e events.Event) (Message, error) {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal: %w", err)
+	}
+	return Message{
+		Topic: events.Topic,
+		Key:   e.VideoID.String(),
+		Value: value,
+	}, nil
+}
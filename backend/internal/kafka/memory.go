@@ -0,0 +1,205 @@
+package broker
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// memoryBacklog bounds what a topic keeps for a group that hasn't subscribed
+// yet, such as the events topic nobody consumes in-process.
+const memoryBacklog = 1000
+
+// Memory is a Broker that keeps everything in the process. It is meant for
+// tests and single-node deployments where the API and the worker run in one
+// binary; nothing survives a restart.
+type Memory struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	nextID uint64
+	closed bool
+}
+
+type memoryTopic struct {
+	groups map[string]*memoryGroup
+	// backlog holds the last messages published before any group subscribed;
+	// the first group to subscribe takes it over.
+	backlog []Message
+}
+
+type memoryGroup struct {
+	queue []Message
+	// changed is closed and replaced whenever queue grows, waking up Fetch.
+	changed chan struct{}
+}
+
+type memoryConsumer struct {
+	broker   *Memory
+	group    *memoryGroup
+	inflight map[uint64]Message
+	closed   bool
+	// toEnd makes Fetch return ErrEndOfTopic instead of waiting for more.
+	toEnd bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{topics: map[string]*memoryTopic{}}
+}
+
+func (m *Memory) topic(name string) *memoryTopic {
+	t, ok := m.topics[name]
+	if !ok {
+		t = &memoryTopic{groups: map[string]*memoryGroup{}}
+		m.topics[name] = t
+	}
+	return t
+}
+
+func (m *Memory) Publish(ctx context.Context, msgs ...Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for _, msg := range msgs {
+		msg.Headers = maps.Clone(msg.Headers)
+		t := m.topic(msg.Topic)
+		if len(t.groups) == 0 {
+			if len(t.backlog) == memoryBacklog {
+				t.backlog = t.backlog[1:]
+			}
+			t.backlog = append(t.backlog, msg)
+			continue
+		}
+		for _, g := range t.groups {
+			g.push(msg)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic, group string) (Consumer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	t := m.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memoryGroup{queue: t.backlog, changed: make(chan struct{})}
+		t.backlog = nil
+		t.groups[group] = g
+	}
+	return &memoryConsumer{broker: m, group: g, inflight: map[uint64]Message{}}, nil
+}
+
+func (m *Memory) subscribeToEnd(ctx context.Context, topic, group string) (Consumer, error) {
+	c, err := m.Subscribe(topic, group)
+	if err != nil {
+		return nil, err
+	}
+	c.(*memoryConsumer).toEnd = true
+	return c, nil
+}
+
+// Close stops every consumer; messages still queued are dropped.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	for _, t := range m.topics {
+		for _, g := range t.groups {
+			g.wake()
+		}
+	}
+	return nil
+}
+
+func (g *memoryGroup) push(msg Message) {
+	g.queue = append(g.queue, msg)
+	g.wake()
+}
+
+func (g *memoryGroup) wake() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+func (c *memoryConsumer) Fetch(ctx context.Context) (*Delivery, error) {
+	m := c.broker
+	for {
+		m.mu.Lock()
+		if m.closed || c.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if len(c.group.queue) > 0 {
+			msg := c.group.queue[0]
+			c.group.queue = c.group.queue[1:]
+			m.nextID++
+			id := m.nextID
+			c.inflight[id] = msg
+			m.mu.Unlock()
+			return &Delivery{
+				Message: msg,
+				ack: func(context.Context) error {
+					m.mu.Lock()
+					defer m.mu.Unlock()
+					delete(c.inflight, id)
+					return nil
+				},
+				nack: func(context.Context) error {
+					m.mu.Lock()
+					defer m.mu.Unlock()
+					c.redeliver(id)
+					return nil
+				},
+			}, nil
+		}
+		if c.toEnd {
+			m.mu.Unlock()
+			return nil, ErrEndOfTopic
+		}
+		changed := c.group.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// redeliver puts an unacknowledged message back at the front of the group
+// queue. Callers hold the broker lock.
+func (c *memoryConsumer) redeliver(id uint64) {
+	msg, ok := c.inflight[id]
+	if !ok {
+		return
+	}
+	delete(c.inflight, id)
+	c.group.queue = append([]Message{msg}, c.group.queue...)
+	c.group.wake()
+}
+
+func (c *memoryConsumer) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for id := range c.inflight {
+		c.redeliver(id)
+	}
+	c.group.wake()
+	return nil
+}
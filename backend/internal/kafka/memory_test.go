@@ -0,0 +1,214 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testTopic = "test-topic"
+
+func publish(t *testing.T, m *Memory, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		if err := m.Publish(context.Background(), Message{Topic: testTopic, Key: "key", Value: []byte(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func subscribe(t *testing.T, m *Memory, topic string) Consumer {
+	t.Helper()
+	c, err := m.Subscribe(topic, "test-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func fetch(t *testing.T, c Consumer) *Delivery {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, err := c.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	return d
+}
+
+// drain fetches what is left for a new consumer of the group.
+func drain(t *testing.T, m *Memory, topic string) []string {
+	t.Helper()
+	c := subscribe(t, m, topic)
+	var values []string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		d, err := c.Fetch(ctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return values
+		}
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		values = append(values, string(d.Value))
+		if err := d.Ack(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func values(from, to int) []string {
+	var out []string
+	for i := from; i < to; i++ {
+		out = append(out, strconv.Itoa(i))
+	}
+	return out
+}
+
+func TestMemoryDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, m *Memory)
+		want []string
+	}{
+		{
+			name: "ack removes the message",
+			run: func(t *testing.T, m *Memory) {
+				c := subscribe(t, m, testTopic)
+				publish(t, m, "a", "b")
+				if err := fetch(t, c).Ack(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				c.Close()
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "nack redelivers first",
+			run: func(t *testing.T, m *Memory) {
+				c := subscribe(t, m, testTopic)
+				publish(t, m, "a", "b")
+				if err := fetch(t, c).Nack(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "close redelivers unacked",
+			run: func(t *testing.T, m *Memory) {
+				c := subscribe(t, m, testTopic)
+				publish(t, m, "a", "b", "c")
+				if err := fetch(t, c).Ack(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				fetch(t, c)
+				c.Close()
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "ack after close is ignored",
+			run: func(t *testing.T, m *Memory) {
+				c := subscribe(t, m, testTopic)
+				publish(t, m, "a")
+				d := fetch(t, c)
+				c.Close()
+				if err := d.Ack(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "backlog is kept in order",
+			run: func(t *testing.T, m *Memory) {
+				publish(t, m, "a", "b", "c")
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "backlog drops the oldest",
+			run: func(t *testing.T, m *Memory) {
+				publish(t, m, values(0, memoryBacklog+2)...)
+			},
+			want: values(2, memoryBacklog+2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			t.Cleanup(func() { m.Close() })
+			tt.run(t, m)
+			got := drain(t, m, testTopic)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages %v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("message %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryPriorities(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority string
+		topic    string
+	}{
+		{name: "high", priority: PriorityHigh, topic: JobTopic(PriorityHigh)},
+		{name: "normal", priority: PriorityNormal, topic: JobTopic(PriorityNormal)},
+		{name: "bulk", priority: PriorityBulk, topic: JobTopic(PriorityBulk)},
+		{name: "none", priority: "", topic: JobTopic(PriorityNormal)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			t.Cleanup(func() { m.Close() })
+			consumers, err := SubscribeJobs(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A backlog in another tier doesn't hold the job back.
+			for _, priority := range Priorities {
+				if JobTopic(priority) == tt.topic {
+					continue
+				}
+				for range 3 {
+					msg, _ := JobMessage(VideoJob{JobID: uuid.New(), Priority: priority})
+					if err := m.Publish(context.Background(), msg); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			job := VideoJob{JobID: uuid.New(), Priority: tt.priority}
+			msg, err := JobMessage(job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Publish(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+
+			d := fetch(t, consumers[tt.topic])
+			var got VideoJob
+			if err := json.Unmarshal(d.Value, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.JobID != job.JobID {
+				t.Errorf("%s consumer got job %s, want %s", tt.topic, got.JobID, job.JobID)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsetTracker lets messages from the same partition be acknowledged in any
+// order while only committing an offset once every message before it has
+// been acknowledged.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending map[topicPartition][]*trackedMessage
+	// acked is the last message of each partition that may be committed.
+	acked map[topicPartition]kafka.Message
+
+	commitMu  sync.Mutex
+	committed map[topicPartition]int64
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+func partitionOf(msg kafka.Message) topicPartition {
+	return topicPartition{topic: msg.Topic, partition: msg.Partition}
+}
+
+type trackedMessage struct {
+	msg    kafka.Message
+	done   bool
+	commit bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		pending:   map[topicPartition][]*trackedMessage{},
+		acked:     map[topicPartition]kafka.Message{},
+		committed: map[topicPartition]int64{},
+	}
+}
+
+// track must be called in fetch order.
+func (t *offsetTracker) track(msg kafka.Message) *trackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := &trackedMessage{msg: msg}
+	t.pending[partitionOf(msg)] = append(t.pending[partitionOf(msg)], m)
+	return m
+}
+
+// finish marks m as handled and moves the committable offset of its
+// partition forward as far as possible. A message that must not be committed
+// holds back every later offset of its partition. Calling finish again for
+// the same message does nothing, so a failed commit can simply be retried.
+func (t *offsetTracker) finish(m *trackedMessage, commit bool) topicPartition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := partitionOf(m.msg)
+	if m.done {
+		return tp
+	}
+	m.done, m.commit = true, commit
+
+	queue := t.pending[tp]
+	n := 0
+	for n < len(queue) && queue[n].done && queue[n].commit {
+		n++
+	}
+	if n > 0 {
+		t.acked[tp] = queue[n-1].msg
+		t.pending[tp] = queue[n:]
+	}
+	return tp
+}
+
+// commit runs fn with the last committable message of tp unless it was
+// committed already; concurrent commits never move the group offset
+// backwards.
+func (t *offsetTracker) commit(tp topicPartition, fn func(kafka.Message) error) error {
+	t.commitMu.Lock()
+	defer t.commitMu.Unlock()
+	t.mu.Lock()
+	msg, ok := t.acked[tp]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if last, ok := t.committed[tp]; ok && last >= msg.Offset {
+		return nil
+	}
+	if err := fn(msg); err != nil {
+		return err
+	}
+	t.committed[tp] = msg.Offset
+	return nil
+}
@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityBulk   = "bulk"
+)
+
+// Priorities lists the job tiers from the most to the least urgent. Each one
+// has its own topic, so a backlog in one tier never sits in front of another.
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityBulk}
+
+var ErrInvalidPriority = errors.New("priority must be high, normal or bulk")
+
+// ParsePriority validates a priority given by a client; empty means normal.
+func ParsePriority(s string) (string, error) {
+	switch s {
+	case "":
+		return PriorityNormal, nil
+	case PriorityHigh, PriorityNormal, PriorityBulk:
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidPriority, s)
+}
+
+// JobTopic returns the topic of priority. Jobs published before priorities
+// existed carry none and go to the normal topic.
+func JobTopic(priority string) string {
+	switch priority {
+	case PriorityHigh, PriorityBulk:
+		return TopicJobs + "-" + priority
+	}
+	return TopicJobs + "-" + PriorityNormal
+}
+
+// jobTopicOf finds the topic msg belongs on from the priority in its payload,
+// which also works for messages coming back from the dead-letter topic.
+func jobTopicOf(msg Message) string {
+	var job struct {
+		Priority string `json:"priority"`
+	}
+	_ = json.Unmarshal(msg.Value, &job)
+	return JobTopic(job.Priority)
+}
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderAttempt  = "x-attempt"
+	HeaderError    = "x-error"
+	HeaderFailedAt = "x-failed-at"
+)
+
+// Attempt reads the attempt counter of msg; messages without the header are
+// on their first attempt.
+func Attempt(msg Message) int {
+	if n, err := strconv.Atoi(msg.Headers[HeaderAttempt]); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Requeue publishes msg to the job topic of its priority again with the
+// given attempt number.
+func Requeue(ctx context.Context, publisher Publisher, msg Message, attempt int) error {
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	delete(headers, HeaderError)
+	delete(headers, HeaderFailedAt)
+	headers[HeaderAttempt] = strconv.Itoa(attempt)
+	out := Message{
+		Topic:   jobTopicOf(msg),
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+	if err := publisher.Publish(ctx, out); err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+	return nil
+}
+
+// DeadLetter parks msg on the dead-letter topic together with the error that
+// exhausted its retries.
+func DeadLetter(ctx context.Context, publisher Publisher, msg Message, cause error) error {
+	headers := maps.Clone(msg.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[HeaderAttempt] = strconv.Itoa(Attempt(msg))
+	headers[HeaderError] = cause.Error()
+	headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	out := Message{
+		Topic:   TopicJobsDLQ,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+	if err := publisher.Publish(ctx, out); err != nil {
+		return fmt.Errorf("failed to write message to DLQ: %w", err)
+	}
+	return nil
+}
+
+// ReplayDLQ moves up to limit messages from the dead-letter topic back onto
+// the job topics with a fresh attempt counter. It reads the topic up to the
+// end it had when the replay started, so messages dead-lettered meanwhile are
+// left for the next replay. wait bounds how long reading one message may take.
+func ReplayDLQ(ctx context.Context, b Broker, limit int, wait time.Duration) ([]VideoJob, error) {
+	sub, ok := b.(endSubscriber)
+	if !ok {
+		return nil, fmt.Errorf("%T can't replay the DLQ", b)
+	}
+	consumer, err := sub.subscribeToEnd(ctx, TopicJobsDLQ, GroupDLQReplay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DLQ: %w", err)
+	}
+	defer consumer.Close()
+
+	replayed := []VideoJob{}
+	for len(replayed) < limit {
+		fetchCtx, cancel := context.WithTimeout(ctx, wait)
+		d, err := consumer.Fetch(fetchCtx)
+		cancel()
+		if errors.Is(err, ErrEndOfTopic) {
+			break
+		}
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read DLQ: %w", err)
+		}
+		if err := Requeue(ctx, b, d.Message, 1); err != nil {
+			d.Nack(ctx)
+			return replayed, err
+		}
+		if err := d.Ack(ctx); err != nil {
+			return replayed, fmt.Errorf("failed to commit DLQ message: %w", err)
+		}
+		var job VideoJob
+		_ = json.Unmarshal(d.Value, &job)
+		replayed = append(replayed, job)
+	}
+	return replayed, nil
+}
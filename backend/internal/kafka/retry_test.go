@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func jobMessage(t *testing.T, priority string, headers map[string]string) (VideoJob, Message) {
+	t.Helper()
+	job := VideoJob{JobID: uuid.New(), VideoID: uuid.New(), Priority: priority}
+	msg, err := JobMessage(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Headers = headers
+	return job, msg
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{header: "", want: 1},
+		{header: "1", want: 1},
+		{header: "4", want: 4},
+		{header: "0", want: 1},
+		{header: "-2", want: 1},
+		{header: "two", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			msg := Message{Headers: map[string]string{}}
+			if tt.header != "" {
+				msg.Headers[HeaderAttempt] = tt.header
+			}
+			if got := Attempt(msg); got != tt.want {
+				t.Errorf("Attempt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority string
+		headers  map[string]string
+		attempt  int
+	}{
+		{name: "first retry", priority: PriorityNormal, attempt: 2},
+		{name: "later retry", priority: PriorityHigh, headers: map[string]string{HeaderAttempt: "2", "trace": "abc"}, attempt: 3},
+		{
+			name:     "from the DLQ",
+			priority: PriorityBulk,
+			headers:  map[string]string{HeaderAttempt: "5", HeaderError: "ffmpeg failed", HeaderFailedAt: "2025-11-01T09:00:00Z"},
+			attempt:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			t.Cleanup(func() { m.Close() })
+			_, msg := jobMessage(t, tt.priority, tt.headers)
+			original := map[string]string{}
+			for k, v := range tt.headers {
+				original[k] = v
+			}
+			if err := Requeue(context.Background(), m, msg, tt.attempt); err != nil {
+				t.Fatal(err)
+			}
+
+			c := subscribe(t, m, JobTopic(tt.priority))
+			got := fetch(t, c)
+			if got.Key != msg.Key || string(got.Value) != string(msg.Value) {
+				t.Errorf("requeued %s = %s, want %s = %s", got.Key, got.Value, msg.Key, msg.Value)
+			}
+			if Attempt(got.Message) != tt.attempt {
+				t.Errorf("Attempt() = %d, want %d", Attempt(got.Message), tt.attempt)
+			}
+			for _, h := range []string{HeaderError, HeaderFailedAt} {
+				if _, ok := got.Headers[h]; ok {
+					t.Errorf("header %s was kept", h)
+				}
+			}
+			if tt.headers["trace"] != "" && got.Headers["trace"] != tt.headers["trace"] {
+				t.Errorf("header trace = %q, want %q", got.Headers["trace"], tt.headers["trace"])
+			}
+			for k, v := range original {
+				if msg.Headers[k] != v {
+					t.Errorf("the headers of the original message were changed")
+				}
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		attempt int
+	}{
+		{name: "without attempt", attempt: 1},
+		{name: "last attempt", headers: map[string]string{HeaderAttempt: "5"}, attempt: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			t.Cleanup(func() { m.Close() })
+			_, msg := jobMessage(t, PriorityHigh, tt.headers)
+			if err := DeadLetter(context.Background(), m, msg, errors.New("ffmpeg failed")); err != nil {
+				t.Fatal(err)
+			}
+			if got := drain(t, m, JobTopic(PriorityHigh)); len(got) != 0 {
+				t.Errorf("the job topic got %d messages", len(got))
+			}
+
+			got := fetch(t, subscribe(t, m, TopicJobsDLQ))
+			if got.Key != msg.Key || string(got.Value) != string(msg.Value) {
+				t.Errorf("dead-lettered %s = %s, want %s = %s", got.Key, got.Value, msg.Key, msg.Value)
+			}
+			if Attempt(got.Message) != tt.attempt {
+				t.Errorf("Attempt() = %d, want %d", Attempt(got.Message), tt.attempt)
+			}
+			if got.Headers[HeaderError] != "ffmpeg failed" {
+				t.Errorf("header %s = %q", HeaderError, got.Headers[HeaderError])
+			}
+			if _, err := time.Parse(time.RFC3339, got.Headers[HeaderFailedAt]); err != nil {
+				t.Errorf("header %s: %v", HeaderFailedAt, err)
+			}
+		})
+	}
+}
+
+func TestReplayDLQ(t *testing.T) {
+	m := NewMemory()
+	t.Cleanup(func() { m.Close() })
+	ctx := context.Background()
+	var jobs []VideoJob
+	for _, priority := range []string{PriorityHigh, PriorityBulk, PriorityHigh} {
+		job, msg := jobMessage(t, priority, map[string]string{HeaderAttempt: "5"})
+		if err := DeadLetter(ctx, m, msg, errors.New("failed")); err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	const wait = 10 * time.Second
+	start := time.Now()
+	first, err := ReplayDLQ(ctx, m, 2, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ReplayDLQ(ctx, m, 10, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := ReplayDLQ(ctx, m, 10, wait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > wait/2 {
+		t.Errorf("replaying took %s, it waited at the end of the DLQ", elapsed)
+	}
+	if len(first) != 2 || len(rest) != 1 || len(none) != 0 {
+		t.Fatalf("replayed %d, %d and %d jobs, want 2, 1 and 0", len(first), len(rest), len(none))
+	}
+	for i, job := range append(first, rest...) {
+		if job.JobID != jobs[i].JobID {
+			t.Errorf("replayed job %d = %s, want %s", i, job.JobID, jobs[i].JobID)
+		}
+	}
+
+	for topic, want := range map[string]int{JobTopic(PriorityHigh): 2, JobTopic(PriorityBulk): 1} {
+		c := subscribe(t, m, topic)
+		for range want {
+			d := fetch(t, c)
+			var job VideoJob
+			if err := json.Unmarshal(d.Value, &job); err != nil {
+				t.Fatal(err)
+			}
+			if Attempt(d.Message) != 1 || d.Headers[HeaderError] != "" {
+				t.Errorf("job %s was replayed with headers %v", job.JobID, d.Headers)
+			}
+			d.Ack(ctx)
+		}
+	}
+}
@@ -1,24 +1,20 @@
 package broker
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/segmentio/kafka-go"
 )
 
-func Publish(ctx context.Context, writer *kafka.Writer, msg VideoJob) error {
+// JobMessage builds the message that carries msg to the job topic of its
+// priority.
+func JobMessage(msg VideoJob) (Message, error) {
 	value, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal: %w", err)
+		return Message{}, fmt.Errorf("failed to marshal: %w", err)
 	}
-	kmsg := kafka.Message{
-		Key:   []byte(msg.VideoID.String()),
+	return Message{
+		Topic: JobTopic(msg.Priority),
+		Key:   msg.VideoID.String(),
 		Value: value,
-	}
-	if err := writer.WriteMessages(ctx, kmsg); err != nil {
-		return fmt.Errorf("failed to write message to Kafka: %w", err)
-	}
-	return nil
+	}, nil
 }
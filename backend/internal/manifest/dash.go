@@ -0,0 +1,242 @@
+package manifest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	DashManifest  = "manifest.mpd"
+	dashTimescale = 1000
+)
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Xmlns                     string   `xml:"xmlns,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	BaseURL                   string   `xml:"BaseURL,omitempty"`
+	Period                    period   `xml:"Period"`
+}
+
+type period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               int              `xml:"id,attr"`
+	ContentType      string           `xml:"contentType,attr"`
+	MimeType         string           `xml:"mimeType,attr"`
+	SegmentAlignment bool             `xml:"segmentAlignment,attr"`
+	StartWithSAP     int              `xml:"startWithSAP,attr"`
+	Representations  []representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID              string           `xml:"id,attr"`
+	Bandwidth       int              `xml:"bandwidth,attr"`
+	Width           int              `xml:"width,attr"`
+	Height          int              `xml:"height,attr"`
+	Codecs          string           `xml:"codecs,attr"`
+	SegmentTemplate *segmentTemplate `xml:"SegmentTemplate,omitempty"`
+	SegmentList     *segmentList     `xml:"SegmentList,omitempty"`
+}
+
+type segmentTemplate struct {
+	Timescale      int               `xml:"timescale,attr"`
+	Initialization string            `xml:"initialization,attr"`
+	Media          string            `xml:"media,attr"`
+	StartNumber    int               `xml:"startNumber,attr"`
+	Timeline       []timelineSegment `xml:"SegmentTimeline>S"`
+}
+
+// segmentList names every segment of a representation, for manifests whose
+// segment URLs can't be derived from a template, such as presigned ones.
+type segmentList struct {
+	Timescale      int               `xml:"timescale,attr"`
+	Initialization segmentURL        `xml:"Initialization"`
+	Timeline       []timelineSegment `xml:"SegmentTimeline>S"`
+	SegmentURLs    []segmentURL      `xml:"SegmentURL"`
+}
+
+type segmentURL struct {
+	SourceURL string `xml:"sourceURL,attr,omitempty"`
+	Media     string `xml:"media,attr,omitempty"`
+}
+
+type timelineSegment struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+// BuildMPD renders a static DASH manifest that points at the CMAF segments
+// written for HLS, so both protocols share the same media files. baseURL is
+// the location of the rendition directories relative to the manifest.
+func BuildMPD(renditions []Rendition, baseURL string) ([]byte, error) {
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("no renditions to describe")
+	}
+	sorted := slices.Clone(renditions)
+	slices.SortFunc(sorted, func(a, b Rendition) int {
+		return a.Height - b.Height
+	})
+
+	set := adaptationSet{
+		ContentType:      "video",
+		MimeType:         "video/mp4",
+		SegmentAlignment: true,
+		StartWithSAP:     1,
+	}
+	var duration float64
+	for _, r := range sorted {
+		for i, s := range r.Segments {
+			if s.URI != fmt.Sprintf(SegmentPattern, i) {
+				return nil, fmt.Errorf("rendition %s: unexpected segment name %s", r.ID, s.URI)
+			}
+		}
+		duration = max(duration, r.Duration())
+		set.Representations = append(set.Representations, representation{
+			ID:        r.ID,
+			Bandwidth: r.Bandwidth,
+			Width:     r.Width,
+			Height:    r.Height,
+			Codecs:    r.Codecs,
+			SegmentTemplate: &segmentTemplate{
+				Timescale:      dashTimescale,
+				Initialization: "$RepresentationID$/" + InitSegment,
+				Media:          "$RepresentationID$/" + dashNumberTemplate(SegmentPattern),
+				Timeline:       buildTimeline(r.Segments),
+			},
+		})
+	}
+
+	doc := mpd{
+		Xmlns:                     "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:                  "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                      "static",
+		MediaPresentationDuration: isoDuration(duration),
+		MinBufferTime:             isoDuration(SegmentDuration),
+		BaseURL:                   baseURL,
+		Period: period{
+			ID:             "0",
+			Start:          "PT0S",
+			AdaptationSets: []adaptationSet{set},
+		},
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mpd: %w", err)
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// buildTimeline converts segment durations to timescale units, deriving each
+// duration from rounded start times so rounding never accumulates drift.
+func buildTimeline(segments []Segment) []timelineSegment {
+	var timeline []timelineSegment
+	var elapsed float64
+	var start int64
+	for i, s := range segments {
+		elapsed += s.Duration
+		end := int64(math.Round(elapsed * dashTimescale))
+		d := end - start
+		last := len(timeline) - 1
+		if last >= 0 && timeline[last].D == d {
+			timeline[last].R++
+		} else {
+			entry := timelineSegment{D: d}
+			if i == 0 {
+				zero := int64(0)
+				entry.T = &zero
+			}
+			timeline = append(timeline, entry)
+		}
+		start = end
+	}
+	return timeline
+}
+
+// SignMPD turns the segment templates of a manifest written by BuildMPD into
+// lists of the URLs sign returns for each segment. sign is given the URI of
+// the segment relative to the manifest.
+func SignMPD(data []byte, sign func(uri string) (string, error)) ([]byte, error) {
+	var doc mpd
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse mpd: %w", err)
+	}
+	for i := range doc.Period.AdaptationSets {
+		set := &doc.Period.AdaptationSets[i]
+		for j := range set.Representations {
+			r := &set.Representations[j]
+			if r.SegmentTemplate == nil {
+				continue
+			}
+			list, err := signTemplate(doc.BaseURL, r.ID, r.SegmentTemplate, sign)
+			if err != nil {
+				return nil, fmt.Errorf("representation %s: %w", r.ID, err)
+			}
+			r.SegmentTemplate, r.SegmentList = nil, list
+		}
+	}
+	// The signed URLs are absolute.
+	doc.BaseURL = ""
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mpd: %w", err)
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func signTemplate(baseURL, id string, t *segmentTemplate, sign func(string) (string, error)) (*segmentList, error) {
+	list := &segmentList{Timescale: t.Timescale, Timeline: t.Timeline}
+	initURL, err := sign(baseURL + expandTemplate(t.Initialization, id, 0))
+	if err != nil {
+		return nil, err
+	}
+	list.Initialization.SourceURL = initURL
+	count := 0
+	for _, s := range t.Timeline {
+		count += 1 + s.R
+	}
+	for n := t.StartNumber; n < t.StartNumber+count; n++ {
+		media, err := sign(baseURL + expandTemplate(t.Media, id, n))
+		if err != nil {
+			return nil, err
+		}
+		list.SegmentURLs = append(list.SegmentURLs, segmentURL{Media: media})
+	}
+	return list, nil
+}
+
+var numberIdentifier = regexp.MustCompile(`\$Number(%0\d+d)?\$`)
+
+// expandTemplate substitutes the identifiers BuildMPD uses in templates.
+func expandTemplate(template, id string, number int) string {
+	uri := strings.ReplaceAll(template, "$RepresentationID$", id)
+	return numberIdentifier.ReplaceAllStringFunc(uri, func(m string) string {
+		format := numberIdentifier.FindStringSubmatch(m)[1]
+		if format == "" {
+			format = "%d"
+		}
+		return fmt.Sprintf(format, number)
+	})
+}
+
+func dashNumberTemplate(pattern string) string {
+	return strings.Replace(pattern, "%05d", "$Number%05d$", 1)
+}
+
+func isoDuration(seconds float64) string {
+	return fmt.Sprintf("PT%.3fS", seconds)
+}
@@ -0,0 +1,123 @@
+package manifest
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestBuildMPD(t *testing.T) {
+	type want struct {
+		id        string
+		bandwidth int
+		width     int
+		height    int
+		codecs    string
+		timeline  []timelineSegment
+	}
+	zero := int64(0)
+	tests := []struct {
+		name       string
+		renditions []Rendition
+		duration   string
+		want       []want
+	}{
+		{
+			name: "single rendition",
+			renditions: []Rendition{
+				{ID: "720p", Width: 1280, Height: 720, Bandwidth: 3000000, Codecs: Codecs(720, true), Segments: segments(3, 4, 1)},
+			},
+			duration: "PT12.000S",
+			want: []want{
+				{"720p", 3000000, 1280, 720, "avc1.64001f,mp4a.40.2", []timelineSegment{{T: &zero, D: 4000, R: 2}}},
+			},
+		},
+		{
+			name: "sorted by height with a short last segment",
+			renditions: []Rendition{
+				{ID: "1080p", Width: 1920, Height: 1080, Bandwidth: 6000000, Codecs: Codecs(1080, false),
+					Segments: append(segments(2, 4, 1), Segment{URI: "seg_00002.m4s", Duration: 1.5})},
+				{ID: "360p", Width: 640, Height: 360, Bandwidth: 800000, Codecs: Codecs(360, false),
+					Segments: append(segments(2, 4, 1), Segment{URI: "seg_00002.m4s", Duration: 1.5})},
+			},
+			duration: "PT9.500S",
+			want: []want{
+				{"360p", 800000, 640, 360, "avc1.64001f", []timelineSegment{{T: &zero, D: 4000, R: 1}, {D: 1500}}},
+				{"1080p", 6000000, 1920, 1080, "avc1.640028", []timelineSegment{{T: &zero, D: 4000, R: 1}, {D: 1500}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := BuildMPD(tt.renditions, "hls/")
+			if err != nil {
+				t.Fatalf("BuildMPD() error = %v", err)
+			}
+			var doc mpd
+			if err := xml.Unmarshal(data, &doc); err != nil {
+				t.Fatalf("invalid mpd: %v", err)
+			}
+			if doc.Type != "static" || doc.BaseURL != "hls/" || doc.MediaPresentationDuration != tt.duration {
+				t.Errorf("mpd = type %q, base %q, duration %q", doc.Type, doc.BaseURL, doc.MediaPresentationDuration)
+			}
+			if len(doc.Period.AdaptationSets) != 1 {
+				t.Fatalf("got %d adaptation sets, want 1", len(doc.Period.AdaptationSets))
+			}
+			reps := doc.Period.AdaptationSets[0].Representations
+			if len(reps) != len(tt.want) {
+				t.Fatalf("got %d representations, want %d", len(reps), len(tt.want))
+			}
+			for i, w := range tt.want {
+				r := reps[i]
+				if r.ID != w.id || r.Bandwidth != w.bandwidth || r.Width != w.width || r.Height != w.height || r.Codecs != w.codecs {
+					t.Errorf("representation %d = %+v, want %+v", i, r, w)
+				}
+				tmpl := r.SegmentTemplate
+				if tmpl == nil {
+					t.Fatalf("representation %s has no segment template", r.ID)
+				}
+				if tmpl.Initialization != "$RepresentationID$/init.mp4" || tmpl.Media != "$RepresentationID$/seg_$Number%05d$.m4s" {
+					t.Errorf("template = %q, %q", tmpl.Initialization, tmpl.Media)
+				}
+				if got := expandTemplate(tmpl.Media, r.ID, 2); got != w.id+"/seg_00002.m4s" {
+					t.Errorf("segment 2 of %s = %s", r.ID, got)
+				}
+				if !equalTimeline(tmpl.Timeline, w.timeline) {
+					t.Errorf("timeline of %s = %v, want %v", r.ID, tmpl.Timeline, w.timeline)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMPDErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		renditions []Rendition
+	}{
+		{"no renditions", nil},
+		{"segment outside the pattern", []Rendition{{ID: "720p", Height: 720, Segments: []Segment{{URI: "other.m4s", Duration: 4}}}}},
+		{"segment out of order", []Rendition{{ID: "720p", Height: 720, Segments: []Segment{{URI: "seg_00001.m4s", Duration: 4}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BuildMPD(tt.renditions, ""); err == nil {
+				t.Error("BuildMPD() error = nil")
+			}
+		})
+	}
+}
+
+func equalTimeline(a, b []timelineSegment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].D != b[i].D || a[i].R != b[i].R || (a[i].T == nil) != (b[i].T == nil) {
+			return false
+		}
+		if a[i].T != nil && *a[i].T != *b[i].T {
+			return false
+		}
+	}
+	return true
+}
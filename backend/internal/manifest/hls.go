@@ -0,0 +1,181 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	SegmentDuration = 4
+	MasterPlaylist  = "master.m3u8"
+	MediaPlaylist   = "index.m3u8"
+	InitSegment     = "init.mp4"
+	SegmentPattern  = "seg_%05d.m4s"
+)
+
+type Segment struct {
+	URI      string
+	Duration float64
+	Size     int64
+}
+
+type Rendition struct {
+	ID               string
+	Width            int
+	Height           int
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	Segments         []Segment
+}
+
+func (r Rendition) PlaylistURI() string {
+	return path.Join(r.ID, MediaPlaylist)
+}
+
+func (r Rendition) Duration() float64 {
+	var total float64
+	for _, s := range r.Segments {
+		total += s.Duration
+	}
+	return total
+}
+
+// ComputeBandwidth fills Bandwidth with the peak segment bitrate and
+// AverageBandwidth with the bitrate over the whole rendition.
+func (r *Rendition) ComputeBandwidth() {
+	var totalSize int64
+	var totalDuration float64
+	peak := 0.0
+	for _, s := range r.Segments {
+		totalSize += s.Size
+		totalDuration += s.Duration
+		if s.Duration > 0 {
+			peak = max(peak, float64(s.Size*8)/s.Duration)
+		}
+	}
+	if totalDuration > 0 {
+		r.AverageBandwidth = int(float64(totalSize*8) / totalDuration)
+	}
+	r.Bandwidth = max(int(peak), r.AverageBandwidth)
+}
+
+func ParseMediaPlaylist(data []byte) ([]Segment, error) {
+	var segments []Segment
+	var duration float64
+	pending := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment duration %q: %w", value, err)
+			}
+			duration = d
+			pending = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if !pending {
+				return nil, fmt.Errorf("segment %s has no duration", line)
+			}
+			segments = append(segments, Segment{URI: line, Duration: duration})
+			pending = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// RewritePlaylist replaces every URI in a playlist, both on its own lines and
+// in the URI attribute of tags such as EXT-X-MAP, with what rewrite returns.
+func RewritePlaylist(data []byte, rewrite func(uri string) (string, error)) ([]byte, error) {
+	var b bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			var err error
+			line = uriAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				if err != nil {
+					return attr
+				}
+				var uri string
+				uri, err = rewrite(uriAttribute.FindStringSubmatch(attr)[1])
+				return `URI="` + uri + `"`
+			})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			uri, err := rewrite(line)
+			if err != nil {
+				return nil, err
+			}
+			line = uri
+		}
+		b.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func BuildMasterPlaylist(renditions []Rendition) []byte {
+	sorted := slices.Clone(renditions)
+	slices.SortFunc(sorted, func(a, b Rendition) int {
+		return a.Height - b.Height
+	})
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, r := range sorted {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n",
+			r.Bandwidth, r.AverageBandwidth, r.Width, r.Height, r.Codecs)
+		b.WriteString(r.PlaylistURI() + "\n")
+	}
+	return b.Bytes()
+}
+
+// VideoCodec returns the avc1 codec string for a High profile H.264 stream
+// with a level large enough for the given height.
+func VideoCodec(height int) string {
+	level := "3c"
+	switch {
+	case height <= 720:
+		level = "1f"
+	case height <= 1080:
+		level = "28"
+	case height <= 1440:
+		level = "32"
+	case height <= 2160:
+		level = "33"
+	}
+	return "avc1.6400" + level
+}
+
+func Codecs(height int, hasAudio bool) string {
+	if hasAudio {
+		return VideoCodec(height) + ",mp4a.40.2"
+	}
+	return VideoCodec(height)
+}
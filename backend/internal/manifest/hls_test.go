@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// segments returns n segments named after SegmentPattern, each lasting
+// duration seconds and holding size bytes.
+func segments(n int, duration float64, size int64) []Segment {
+	s := make([]Segment, n)
+	for i := range s {
+		s[i] = Segment{URI: fmt.Sprintf(SegmentPattern, i), Duration: duration, Size: size}
+	}
+	return s
+}
+
+func TestBuildMasterPlaylist(t *testing.T) {
+	tests := []struct {
+		name       string
+		renditions []Rendition
+		want       []string
+	}{
+		{
+			name: "single rendition",
+			renditions: []Rendition{
+				{ID: "720p", Width: 1280, Height: 720, Bandwidth: 3000000, AverageBandwidth: 2500000, Codecs: Codecs(720, true)},
+			},
+			want: []string{
+				"#EXTM3U",
+				"#EXT-X-VERSION:7",
+				"#EXT-X-INDEPENDENT-SEGMENTS",
+				`#EXT-X-STREAM-INF:BANDWIDTH=3000000,AVERAGE-BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="avc1.64001f,mp4a.40.2"`,
+				"720p/index.m3u8",
+			},
+		},
+		{
+			name: "sorted by height",
+			renditions: []Rendition{
+				{ID: "1080p", Width: 1920, Height: 1080, Bandwidth: 6000000, AverageBandwidth: 5000000, Codecs: Codecs(1080, false)},
+				{ID: "360p", Width: 640, Height: 360, Bandwidth: 800000, AverageBandwidth: 700000, Codecs: Codecs(360, false)},
+			},
+			want: []string{
+				"#EXTM3U",
+				"#EXT-X-VERSION:7",
+				"#EXT-X-INDEPENDENT-SEGMENTS",
+				`#EXT-X-STREAM-INF:BANDWIDTH=800000,AVERAGE-BANDWIDTH=700000,RESOLUTION=640x360,CODECS="avc1.64001f"`,
+				"360p/index.m3u8",
+				`#EXT-X-STREAM-INF:BANDWIDTH=6000000,AVERAGE-BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS="avc1.640028"`,
+				"1080p/index.m3u8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Split(strings.TrimSuffix(string(BuildMasterPlaylist(tt.renditions)), "\n"), "\n")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("BuildMasterPlaylist() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestComputeBandwidth(t *testing.T) {
+	tests := []struct {
+		name        string
+		segments    []Segment
+		wantPeak    int
+		wantAverage int
+	}{
+		{"constant", segments(3, 4, 500000), 1000000, 1000000},
+		{"peak segment", []Segment{{Duration: 4, Size: 500000}, {Duration: 4, Size: 1500000}}, 3000000, 2000000},
+		{"empty", nil, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Rendition{Segments: tt.segments}
+			r.ComputeBandwidth()
+			if r.Bandwidth != tt.wantPeak || r.AverageBandwidth != tt.wantAverage {
+				t.Errorf("bandwidth = %d/%d, want %d/%d", r.Bandwidth, r.AverageBandwidth, tt.wantPeak, tt.wantAverage)
+			}
+		})
+	}
+}
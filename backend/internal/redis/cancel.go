@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const CancelChannel = "jobs:cancel"
+
+const cancelTTL = 24 * time.Hour
+
+func CancelKey(jobID uuid.UUID) string {
+	return fmt.Sprintf("cancel:%s", jobID)
+}
+
+// PublishCancel tells whichever worker holds the job to stop it. The key
+// covers jobs that are still queued or waiting for a retry, so no worker is
+// running them when the message is published.
+func PublishCancel(ctx context.Context, client *redis.Client, jobID uuid.UUID) error {
+	if err := client.Set(ctx, CancelKey(jobID), 1, cancelTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save cancellation: %w", err)
+	}
+	if err := client.Publish(ctx, CancelChannel, jobID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to publish cancellation: %w", err)
+	}
+	return nil
+}
+
+func IsCancelled(ctx context.Context, client *redis.Client, jobID uuid.UUID) (bool, error) {
+	n, err := client.Exists(ctx, CancelKey(jobID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check cancellation: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type Progress struct {
+	VideoID   uuid.UUID          `json:"video_id"`
+	JobID     uuid.UUID          `json:"job_id"`
+	Status    string             `json:"status"`
+	Percent   float64            `json:"percent"`
+	Tasks     map[string]float64 `json:"tasks"`
+	Error     string             `json:"error,omitempty"`
+	Attempt   int                `json:"attempt,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func ProgressKey(videoID uuid.UUID) string {
+	return fmt.Sprintf("progress:%s", videoID)
+}
+
+// PublishProgress stores the latest snapshot so late subscribers can catch up
+// and fans it out to every API replica listening on the video channel.
+func PublishProgress(ctx context.Context, client *redis.Client, p Progress) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal progress: %w", err)
+	}
+	key := ProgressKey(p.VideoID)
+	if err := client.Set(ctx, key, payload, time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to save progress: %w", err)
+	}
+	if err := client.Publish(ctx, key, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish progress: %w", err)
+	}
+	return nil
+}
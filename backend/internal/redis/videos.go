@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// VideoKey caches the response of GET /video/:id.
+func VideoKey(videoID uuid.UUID) string {
+	return videoID.String()
+}
+
+// VideoListKey caches a page of GET /video.
+func VideoListKey(limit, offset string) string {
+	return fmt.Sprintf("videos_%s_%s", limit, offset)
+}
+
+// SubtitlesKey caches the URL of the subtitles of a video in lang.
+func SubtitlesKey(videoID, lang string) string {
+	return fmt.Sprintf("%s_%s_sub", videoID, lang)
+}
+
+// InvalidateVideo removes the cached responses about a video, including every
+// page of the video list since any of them may show it.
+func InvalidateVideo(ctx context.Context, client *redis.Client, videoID uuid.UUID) error {
+	return deleteKeys(ctx, client, []string{VideoKey(videoID)}, SubtitlesKey(videoID.String(), "*"), VideoListKey("*", "*"))
+}
+
+// DeleteVideo removes everything cached about a video, its progress too.
+func DeleteVideo(ctx context.Context, client *redis.Client, videoID uuid.UUID) error {
+	return deleteKeys(ctx, client, []string{VideoKey(videoID), ProgressKey(videoID)}, SubtitlesKey(videoID.String(), "*"), VideoListKey("*", "*"))
+}
+
+// deleteKeys deletes keys and every key matching one of patterns.
+func deleteKeys(ctx context.Context, client *redis.Client, keys []string, patterns ...string) error {
+	for _, pattern := range patterns {
+		iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to find cached keys: %w", err)
+		}
+	}
+	if err := client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete cached keys: %w", err)
+	}
+	return nil
+}
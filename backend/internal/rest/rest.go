@@ -1,19 +1,21 @@
 package rest
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
 )
 
-func Upscale(id uuid.UUID, baseUrl string, height int, realistic bool) error {
+func Upscale(ctx context.Context, id uuid.UUID, baseUrl string, height int, realistic bool) error {
 	file := strconv.Itoa(height)
 	url := fmt.Sprintf("%s/upscale/%s?file=%s&real=%t", baseUrl, id, file, realistic)
 	client := http.Client{}
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -22,12 +24,12 @@ func Upscale(id uuid.UUID, baseUrl string, height int, realistic bool) error {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
-	return nil
+	return checkStatus(resp)
 }
-func CreateSubtitles(id uuid.UUID, baseUrl string) (string, error) {
+func CreateSubtitles(ctx context.Context, id uuid.UUID, baseUrl string) (string, error) {
 	url := fmt.Sprintf("%s/subtitles/%s", baseUrl, id)
 	client := http.Client{}
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -35,12 +37,15 @@ func CreateSubtitles(id uuid.UUID, baseUrl string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read body:%w", err)
 	}
 	lang := string(body)
-	defer resp.Body.Close()
 	return lang, nil
 }
 func TranslateSubtitles(id uuid.UUID, baseUrl string, to string) error {
@@ -72,3 +77,17 @@ func CreateDubbing(id uuid.UUID, baseUrl string, to string) error {
 	defer resp.Body.Close()
 	return nil
 }
+
+// checkStatus turns a non-2xx response into an error. Client errors won't go
+// away on their own, so they are marked permanent and not retried.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("%s returned %s: %s", resp.Request.URL.Path, resp.Status, body)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return retry.Permanent(err)
+	}
+	return err
+}
@@ -2,13 +2,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	"github.com/ksamf/video-upscaling/backend/internal/config"
 	"github.com/minio/minio-go/v7"
 )
 
-func NewBucket(conn *minio.Client, conf *config.Config) *Storage {
+func NewBucket(conn *minio.Client, conf *config.Config) (*Storage, error) {
 	storage := &Storage{
 		Endpoint:        conf.S3.EndpointURL,
 		AccessKeyID:     conf.S3.AccessKeyID,
@@ -19,13 +20,12 @@ func NewBucket(conn *minio.Client, conf *config.Config) *Storage {
 	err := conn.MakeBucket(context.Background(), storage.BucketName, minio.MakeBucketOptions{})
 	if err != nil {
 		exists, errBucketExists := conn.BucketExists(context.Background(), storage.BucketName)
-		if errBucketExists == nil && exists {
-			log.Printf("We already own %s\n", storage.BucketName)
-		} else {
-			log.Fatalln(err)
+		if errBucketExists != nil || !exists {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", storage.BucketName, err)
 		}
+		log.Printf("We already own %s\n", storage.BucketName)
 	} else {
 		log.Printf("Successfully created %s\n", storage.BucketName)
 	}
-	return storage
+	return storage, nil
 }
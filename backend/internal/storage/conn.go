@@ -1,21 +1,22 @@
 package storage
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/ksamf/video-upscaling/backend/internal/config"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-func New(conf *config.Config) *minio.Client {
+// NewS3 connects to the S3 endpoint in conf and creates the bucket if it
+// doesn't exist yet.
+func NewS3(conf *config.Config) (*Storage, error) {
 	conn, err := minio.New(conf.S3.EndpointURL, &minio.Options{
 		Creds:  credentials.NewStaticV4(conf.S3.AccessKeyID, conf.S3.SecretAccessKey, ""),
 		Secure: false,
 	})
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
 	}
-
-	return conn
+	return NewBucket(conn, conf)
 }
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer grants access to a name until an expiry, with an HMAC over both
+// that is checked without keeping any state. Presigned object URLs use it,
+// and so do the playback URLs of private videos.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Sign returns the query parameters that grant access to name for expires.
+func (s *Signer) Sign(name string, expires time.Duration) url.Values {
+	expiry := time.Now().Add(expires).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiry, 10))
+	query.Set("signature", s.sign(name, expiry))
+	return query
+}
+
+// Verify reports whether query holds a signature for name that hasn't
+// expired.
+func (s *Signer) Verify(name string, query url.Values) bool {
+	signature := query.Get("signature")
+	expiry, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if signature == "" || err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.sign(name, expiry)))
+}
+
+func (s *Signer) sign(name string, expiry int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", name, expiry)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FileServer serves the objects of a store that has no HTTP endpoint of its
+// own, the way a private bucket would: only at presigned URLs, which carry an
+// expiry and a signature over the key and the expiry, and are refused once
+// either doesn't check out.
+type FileServer struct {
+	store   ObjectStore
+	baseURL string
+	signer  *Signer
+}
+
+func newFileServer(store ObjectStore, baseURL string, secret []byte) *FileServer {
+	return &FileServer{store: store, baseURL: strings.TrimSuffix(baseURL, "/"), signer: NewSigner(secret)}
+}
+
+func (f *FileServer) objectURL(key string) string {
+	return f.baseURL + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+// Signer returns the signer of the presigned URLs.
+func (f *FileServer) Signer() *Signer {
+	return f.signer
+}
+
+func (f *FileServer) presign(key string, expires time.Duration) string {
+	return f.objectURL(key) + "?" + f.signer.Sign(key, expires).Encode()
+}
+
+// ServeHTTP serves the object named by the request path, which must have the
+// prefix of the public URL stripped already.
+func (f *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	if !query.Has("signature") {
+		http.Error(w, "signature required", http.StatusForbidden)
+		return
+	}
+	if !f.signer.Verify(key, query) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	if err := Serve(w, r, f.store, key); err != nil {
+		switch {
+		case IsNotFound(err):
+			http.NotFound(w, r)
+		case errors.Is(err, errInvalidKey):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "failed to read object", http.StatusInternalServerError)
+		}
+	}
+}
+
+// Serve answers r with the object at key, honouring Range, If-Range and the
+// other conditional headers against its ETag and modification time. Only the
+// ranges asked for are read from the store, as the response is written. The
+// Content-Type and Cache-Control the object was stored with are sent unless
+// they are set on w already. Serve writes nothing if it returns an error.
+func Serve(w http.ResponseWriter, r *http.Request, store ObjectStore, key string) error {
+	info, err := store.Stat(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	obj, err := store.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	header := w.Header()
+	if info.ETag != "" {
+		header.Set("ETag", strconv.Quote(info.ETag))
+	}
+	if header.Get("Content-Type") == "" && info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	if header.Get("Cache-Control") == "" && info.CacheControl != "" {
+		header.Set("Cache-Control", info.CacheControl)
+	}
+	http.ServeContent(w, r, path.Base(key), info.LastModified, obj)
+	return nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	tests := []struct {
+		name  string
+		query func() url.Values
+		valid bool
+	}{
+		{
+			name:  "valid",
+			query: func() url.Values { return signer.Sign("video/240.mp4", time.Minute) },
+			valid: true,
+		},
+		{
+			name:  "expired",
+			query: func() url.Values { return signer.Sign("video/240.mp4", -time.Second) },
+		},
+		{
+			name: "other name",
+			query: func() url.Values {
+				return signer.Sign("video/1080.mp4", time.Minute)
+			},
+		},
+		{
+			name: "other secret",
+			query: func() url.Values {
+				return NewSigner([]byte("other")).Sign("video/240.mp4", time.Minute)
+			},
+		},
+		{
+			name: "expiry extended",
+			query: func() url.Values {
+				q := signer.Sign("video/240.mp4", time.Minute)
+				q.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+				return q
+			},
+		},
+		{
+			name: "signature changed",
+			query: func() url.Values {
+				q := signer.Sign("video/240.mp4", time.Minute)
+				sig := []byte(q.Get("signature"))
+				sig[0] ^= 1
+				q.Set("signature", string(sig))
+				return q
+			},
+		},
+		{
+			name: "no signature",
+			query: func() url.Values {
+				q := signer.Sign("video/240.mp4", time.Minute)
+				q.Del("signature")
+				return q
+			},
+		},
+		{
+			name: "no expiry",
+			query: func() url.Values {
+				q := signer.Sign("video/240.mp4", time.Minute)
+				q.Del("expires")
+				return q
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signer.Verify("video/240.mp4", tt.query()); got != tt.valid {
+				t.Errorf("Verify() = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	const baseURL = "http://objects.test"
+	ctx := context.Background()
+	m := NewMemory(baseURL)
+	data := []byte("0123456789")
+	if err := m.Put(ctx, "video/240.mp4", bytes.NewReader(data), int64(len(data)), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	presigned := func(key string, expires time.Duration) string {
+		u, err := m.PresignGet(ctx, key, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimPrefix(u, baseURL)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header http.Header
+		status int
+		body   string
+	}{
+		{name: "presigned", target: presigned("video/240.mp4", time.Minute), status: http.StatusOK, body: string(data)},
+		{name: "range", target: presigned("video/240.mp4", time.Minute), header: http.Header{"Range": {"bytes=2-4"}}, status: http.StatusPartialContent, body: "234"},
+		{name: "head", method: http.MethodHead, target: presigned("video/240.mp4", time.Minute), status: http.StatusOK},
+		{name: "unsigned", target: "/video/240.mp4", status: http.StatusForbidden},
+		{name: "expired", target: presigned("video/240.mp4", -time.Second), status: http.StatusForbidden},
+		{name: "signed for another key", target: "/video/240.mp4?" + mustQuery(t, presigned("video/1080.mp4", time.Minute)), status: http.StatusForbidden},
+		{name: "missing object", target: presigned("video/1080.mp4", time.Minute), status: http.StatusNotFound},
+		{name: "post", method: http.MethodPost, target: presigned("video/240.mp4", time.Minute), status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+func mustQuery(t *testing.T, target string) string {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.RawQuery
+}
@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// localUploads holds the parts of multipart uploads, one directory each.
+	localUploads = ".multipart"
+	// localSecret holds the key that signs presigned URLs, shared by every
+	// process using the same directory.
+	localSecret = ".signing-key"
+)
+
+// Local is a Store in a directory on disk, with one file per object. Its
+// objects are served by its FileServer, so the API must be running for
+// presigned URLs to work.
+type Local struct {
+	*FileServer
+	root string
+}
+
+var _ Store = (*Local)(nil)
+
+func NewLocal(root, baseURL string) (*Local, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, localUploads), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	secret, err := localSigningKey(filepath.Join(root, localSecret))
+	if err != nil {
+		return nil, err
+	}
+	l := &Local{root: root}
+	l.FileServer = newFileServer(l, baseURL, secret)
+	return l, nil
+}
+
+func localSigningKey(name string) ([]byte, error) {
+	secret, err := os.ReadFile(name)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	secret = []byte(rand.Text())
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another process created it first.
+		return os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(secret); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return secret, nil
+}
+
+// path maps key to its file. Keys must be clean and can't leave the root or
+// reach the dot files the store keeps for itself.
+func (l *Local) path(key string) (string, error) {
+	if path.Clean(key) != key || !filepath.IsLocal(key) || strings.HasPrefix(key, ".") ||
+		strings.Contains(key, "/.") || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("%w %q", errInvalidKey, key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(localMeta(opts.withDefaults(key)))
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	if err := l.write(name, r); err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	if err := l.write(metaPath(name), bytes.NewReader(meta)); err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+// localMeta holds the options an object was stored with, in a dot file next
+// to it.
+type localMeta struct {
+	ContentType  string            `json:"content_type"`
+	CacheControl string            `json:"cache_control"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func metaPath(name string) string {
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".meta")
+}
+
+// readMeta returns the options the object at name was stored with, or the
+// ones inferred from key if they weren't recorded.
+func readMeta(name, key string) (localMeta, error) {
+	var meta localMeta
+	data, err := os.ReadFile(metaPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return localMeta(PutOptions{}.withDefaults(key)), nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	return meta, json.Unmarshal(data, &meta)
+}
+
+// write replaces name by renaming a complete temporary file over it, so
+// readers never see half an object.
+func (l *Local) write(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, localError("get", key, err)
+	}
+	return f, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, localError("stat", key, err)
+	}
+	meta, err := readMeta(name, key)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	info := localInfo(key, fi)
+	info.ContentType = meta.ContentType
+	info.CacheControl = meta.CacheControl
+	info.Metadata = meta.Metadata
+	return info, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{name, metaPath(name)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete object %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Only the directory the prefix ends in has to be walked.
+	dir := l.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		if dir, err = l.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+	var objects []ObjectInfo
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && name != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, localInfo(key, fi))
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+func (l *Local) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	return l.presign(key, expires), nil
+}
+
+func (l *Local) RemovePrefix(ctx context.Context, prefix string) (int, error) {
+	objects, err := l.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, obj := range objects {
+		if err := l.Delete(ctx, obj.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	uploads, err := os.ReadDir(filepath.Join(l.root, localUploads))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list uploads under %s: %w", prefix, err))
+	}
+	for _, upload := range uploads {
+		key, err := os.ReadFile(filepath.Join(l.root, localUploads, upload.Name(), "key"))
+		if err != nil || !strings.HasPrefix(string(key), prefix) {
+			continue
+		}
+		if err := l.AbortMultipartUpload(ctx, string(key), upload.Name()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+func (l *Local) uploadDir(key, uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	dir := filepath.Join(l.root, localUploads, uploadID)
+	owner, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil {
+		return "", fmt.Errorf("no upload %s: %w", uploadID, err)
+	}
+	if string(owner) != key {
+		return "", fmt.Errorf("upload %s is not for %s", uploadID, key)
+	}
+	return dir, nil
+}
+
+func (l *Local) NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error) {
+	if _, err := l.path(key); err != nil {
+		return "", err
+	}
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload %s: %w", key, err)
+	}
+	id := uuid.NewString()
+	dir := filepath.Join(l.root, localUploads, id)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload %s: %w", key, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o644); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload %s: %w", key, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "options"), options, 0o644); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload %s: %w", key, err)
+	}
+	return id, nil
+}
+
+func (l *Local) PutPart(ctx context.Context, key, uploadID string, number int, reader io.Reader, size int64) (Part, error) {
+	dir, err := l.uploadDir(key, uploadID)
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: %w", number, key, err)
+	}
+	name := filepath.Join(dir, strconv.Itoa(number))
+	if err := l.write(name, reader); err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: %w", number, key, err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: %w", number, key, err)
+	}
+	return Part{Number: number, ETag: localInfo(key, fi).ETag, Size: fi.Size()}, nil
+}
+
+func (l *Local) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, err := l.uploadDir(key, uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload %s: %w", key, err)
+	}
+	var opts PutOptions
+	options, err := os.ReadFile(filepath.Join(dir, "options"))
+	if err == nil {
+		err = json.Unmarshal(options, &opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload %s: %w", key, err)
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(p.Number)))
+		if err != nil {
+			return fmt.Errorf("failed to complete multipart upload %s: %w", key, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if err := l.Put(ctx, key, io.MultiReader(readers...), -1, opts); err != nil {
+		return fmt.Errorf("failed to complete multipart upload %s: %w", key, err)
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *Local) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := l.uploadDir(key, uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload %s: %w", key, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to abort multipart upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *Local) PresignPart(ctx context.Context, key, uploadID string, number int, expires time.Duration) (string, error) {
+	return "", fmt.Errorf("failed to presign part %d of %s: %w", number, key, ErrNotSupported)
+}
+
+func localError(op, key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to %s object %s: %w", op, key, ErrNotFound)
+	}
+	return fmt.Errorf("failed to %s object %s: %w", op, key, err)
+}
+
+func localInfo(key string, fi fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+		ETag:         fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(filepath.Join(root, "objects"), "http://objects.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "video/tmp.mp4", valid: true},
+		{key: "video/hls/240/segment_001.m4s", valid: true},
+		{key: "video/.tmp.mp4.meta"},
+		{key: ".signing-key"},
+		{key: ".multipart/upload/1"},
+		{key: "../outside"},
+		{key: "video/../../outside"},
+		{key: "video/../other"},
+		{key: "/etc/passwd"},
+		{key: "video//tmp.mp4"},
+		{key: "video/./tmp.mp4"},
+		{key: `video\..\..\outside`},
+		{key: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			name, err := l.path(tt.key)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("path() error = %v", err)
+				}
+				if !filepath.IsLocal(mustRel(t, l.root, name)) {
+					t.Errorf("path() = %s, outside of %s", name, l.root)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidKey) {
+				t.Errorf("path() = %s, %v, want %v", name, err, errInvalidKey)
+			}
+			err = l.Put(context.Background(), tt.key, bytes.NewReader([]byte("data")), 4, PutOptions{})
+			if !errors.Is(err, errInvalidKey) {
+				t.Errorf("Put() error = %v, want %v", err, errInvalidKey)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside")); err == nil {
+		t.Error("an object was written outside of the root")
+	}
+}
+
+func mustRel(t *testing.T, base, target string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory is a Store that keeps everything in the process, for tests and for
+// running the API and the worker together on a laptop. Objects are served by
+// its FileServer; nothing survives a restart.
+type Memory struct {
+	*FileServer
+
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+	opts    PutOptions
+}
+
+type memoryUpload struct {
+	key   string
+	opts  PutOptions
+	parts map[int][]byte
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty store whose objects are served at baseURL.
+func NewMemory(baseURL string) *Memory {
+	m := &Memory{
+		objects: map[string]memoryObject{},
+		uploads: map[string]*memoryUpload{},
+	}
+	m.FileServer = newFileServer(m, baseURL, []byte(rand.Text()))
+	return m
+}
+
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	m.put(key, data, opts)
+	return nil
+}
+
+func (m *Memory) put(key string, data []byte, opts PutOptions) {
+	sum := md5.Sum(data)
+	opts = opts.withDefaults(key)
+	opts.Metadata = maps.Clone(opts.Metadata)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: data, modTime: time.Now(), etag: hex.EncodeToString(sum[:]), opts: opts}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	obj, ok := m.objects[key]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, ErrNotFound)
+	}
+	return nopCloser{bytes.NewReader(obj.data)}, nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.Lock()
+	obj, ok := m.objects[key]
+	m.mu.Unlock()
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %s: %w", key, ErrNotFound)
+	}
+	return obj.info(key), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []ObjectInfo
+	for _, key := range slices.Sorted(maps.Keys(m.objects)) {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, m.objects[key].info(key))
+		}
+	}
+	return objects, nil
+}
+
+func (m *Memory) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return m.presign(key, expires), nil
+}
+
+func (m *Memory) RemovePrefix(ctx context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.objects, key)
+			removed++
+		}
+	}
+	for id, upload := range m.uploads {
+		if strings.HasPrefix(upload.key, prefix) {
+			delete(m.uploads, id)
+		}
+	}
+	return removed, nil
+}
+
+func (m *Memory) NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error) {
+	id := uuid.NewString()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[id] = &memoryUpload{key: key, opts: opts, parts: map[int][]byte{}}
+	return id, nil
+}
+
+func (m *Memory) PutPart(ctx context.Context, key, uploadID string, number int, reader io.Reader, size int64) (Part, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: %w", number, key, err)
+	}
+	sum := md5.Sum(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: no upload %s", number, key, uploadID)
+	}
+	upload.parts[number] = data
+	return Part{Number: number, ETag: hex.EncodeToString(sum[:]), Size: int64(len(data))}, nil
+}
+
+func (m *Memory) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	m.mu.Lock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to complete multipart upload %s: no upload %s", key, uploadID)
+	}
+	var data []byte
+	for _, p := range parts {
+		part, ok := upload.parts[p.Number]
+		if !ok {
+			m.mu.Unlock()
+			return fmt.Errorf("failed to complete multipart upload %s: part %d is missing", key, p.Number)
+		}
+		data = append(data, part...)
+	}
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	m.put(key, data, upload.opts)
+	return nil
+}
+
+func (m *Memory) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (m *Memory) PresignPart(ctx context.Context, key, uploadID string, number int, expires time.Duration) (string, error) {
+	return "", fmt.Errorf("failed to presign part %d of %s: %w", number, key, ErrNotSupported)
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		LastModified: o.modTime,
+		ETag:         o.etag,
+		ContentType:  o.opts.ContentType,
+		CacheControl: o.opts.CacheControl,
+		Metadata:     maps.Clone(o.opts.Metadata),
+	}
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
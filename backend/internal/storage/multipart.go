@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	MinPartSize = 5 << 20
+	MaxParts    = 10000
+)
+
+type Part struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+func (s3 *Storage) core() minio.Core {
+	return minio.Core{Client: s3.Client}
+}
+
+func (s3 *Storage) NewMultipartUpload(ctx context.Context, object string, opts PutOptions) (string, error) {
+	uploadID, err := s3.core().NewMultipartUpload(ctx, s3.BucketName, object, putObjectOptions(object, opts))
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload %s: %w", object, err)
+	}
+	return uploadID, nil
+}
+
+func (s3 *Storage) PutPart(ctx context.Context, object, uploadID string, number int, reader io.Reader, size int64) (Part, error) {
+	part, err := s3.core().PutObjectPart(ctx, s3.BucketName, object, uploadID, number, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to put part %d of %s: %w", number, object, err)
+	}
+	return Part{Number: part.PartNumber, ETag: part.ETag, Size: size}, nil
+}
+
+func (s3 *Storage) CompleteMultipartUpload(ctx context.Context, object, uploadID string, parts []Part) error {
+	complete := make([]minio.CompletePart, 0, len(parts))
+	for _, p := range parts {
+		complete = append(complete, minio.CompletePart{PartNumber: p.Number, ETag: p.ETag})
+	}
+	_, err := s3.core().CompleteMultipartUpload(ctx, s3.BucketName, object, uploadID, complete, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload %s: %w", object, err)
+	}
+	return nil
+}
+
+func (s3 *Storage) AbortMultipartUpload(ctx context.Context, object, uploadID string) error {
+	if err := s3.core().AbortMultipartUpload(ctx, s3.BucketName, object, uploadID); err != nil {
+		return fmt.Errorf("failed to abort multipart upload %s: %w", object, err)
+	}
+	return nil
+}
+
+func (s3 *Storage) PresignPart(ctx context.Context, object, uploadID string, number int, expires time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("partNumber", strconv.Itoa(number))
+	params.Set("uploadId", uploadID)
+	u, err := s3.Client.Presign(ctx, "PUT", s3.BucketName, object, expires, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign part %d of %s: %w", number, object, err)
+	}
+	return u.String(), nil
+}
@@ -2,12 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 )
 
@@ -19,9 +20,19 @@ type Storage struct {
 	Client          *minio.Client
 }
 
-func (s3 *Storage) PutObject(object string, reader io.Reader) error {
-	contentType := "application/octet-stream"
-	info, err := s3.Client.PutObject(context.Background(), s3.BucketName, object, reader, -1, minio.PutObjectOptions{ContentType: contentType})
+// Storage is the Store on S3, or MinIO in development.
+var _ Store = (*Storage)(nil)
+
+func isMinioNotFound(err error) bool {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	return resp.Code == "NoSuchKey" || resp.Code == "NoSuchBucket"
+}
+
+func (s3 *Storage) Put(ctx context.Context, object string, reader io.Reader, size int64, opts PutOptions) error {
+	info, err := s3.Client.PutObject(ctx, s3.BucketName, object, reader, size, putObjectOptions(object, opts))
 	if err != nil {
 		return fmt.Errorf("failed to put object %s: %w", object, err)
 	}
@@ -29,52 +40,140 @@ func (s3 *Storage) PutObject(object string, reader io.Reader) error {
 	return nil
 }
 
-func (s3 *Storage) GetObject(object, tmpPath string) error {
-	ctx := context.Background()
-
+// Get returns the *minio.Object itself, which fetches ranges lazily as it is
+// read and seeked.
+func (s3 *Storage) Get(ctx context.Context, object string) (io.ReadSeekCloser, error) {
 	reader, err := s3.Client.GetObject(ctx, s3.BucketName, object, minio.GetObjectOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to get object %s: %w", object, err)
+		return nil, fmt.Errorf("failed to get object %s: %w", object, err)
 	}
-	defer reader.Close()
+	if _, err := reader.Stat(); err != nil {
+		reader.Close()
+		return nil, s3.objectError("get", object, err)
+	}
+	return reader, nil
+}
 
-	localFile, err := os.Create(tmpPath)
+func (s3 *Storage) Stat(ctx context.Context, object string) (ObjectInfo, error) {
+	info, err := s3.Client.StatObject(ctx, s3.BucketName, object, minio.StatObjectOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create tmp file: %w", err)
+		return ObjectInfo{}, s3.objectError("stat", object, err)
 	}
-	defer localFile.Close()
+	return objectInfo(info), nil
+}
 
-	if _, err := io.Copy(localFile, reader); err != nil {
-		return fmt.Errorf("failed to copy reader to local file: %w", err)
+func (s3 *Storage) Delete(ctx context.Context, object string) error {
+	err := s3.Client.RemoveObject(ctx, s3.BucketName, object, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", object, err)
 	}
+	return nil
+}
 
-	if err := localFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync tmp file: %w", err)
+func (s3 *Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range s3.Client.ListObjects(ctx, s3.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list objects under %s: %w", prefix, obj.Err)
+		}
+		objects = append(objects, objectInfo(obj))
 	}
+	return objects, nil
+}
 
-	return nil
+func (s3 *Storage) objectError(op, object string, err error) error {
+	if isMinioNotFound(err) {
+		return fmt.Errorf("failed to %s object %s: %w", op, object, ErrNotFound)
+	}
+	return fmt.Errorf("failed to %s object %s: %w", op, object, err)
 }
 
-func (s3 *Storage) DeleteObject(object string) error {
-	err := s3.Client.RemoveObject(context.Background(), s3.BucketName, object, minio.RemoveObjectOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to delete object %s: %w", object, err)
+func putObjectOptions(object string, opts PutOptions) minio.PutObjectOptions {
+	opts = opts.withDefaults(object)
+	return minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		CacheControl: opts.CacheControl,
+		UserMetadata: opts.Metadata,
 	}
-	return nil
 }
 
-func (s3 *Storage) ExitsObjects(object string) bool {
-	_, err := s3.Client.StatObject(context.Background(), s3.BucketName, object, minio.StatObjectOptions{})
-	if err != nil {
-		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			return false
-		} else {
-			return false
+func objectInfo(info minio.ObjectInfo) ObjectInfo {
+	var meta map[string]string
+	if len(info.UserMetadata) > 0 {
+		meta = make(map[string]string, len(info.UserMetadata))
+		for k, v := range info.UserMetadata {
+			meta[strings.ToLower(k)] = v
 		}
 	}
-	return true
+	return ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		CacheControl: info.Metadata.Get("Cache-Control"),
+		Metadata:     meta,
+	}
 }
 
-func (s3 *Storage) GetURL(id uuid.UUID) string {
-	return fmt.Sprintf("https://%s/%s/%s", s3.Endpoint, s3.BucketName, id.String())
+// RemovePrefix deletes every object under prefix, along with multipart
+// uploads that were never completed, and returns the number of objects
+// removed.
+func (s3 *Storage) RemovePrefix(ctx context.Context, prefix string) (int, error) {
+	objects := make(chan minio.ObjectInfo)
+	// The listing only reports its error here; everything else is collected
+	// by the caller's goroutine.
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		for obj := range s3.Client.ListObjects(ctx, s3.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil {
+				listErr <- obj.Err
+				return
+			}
+			select {
+			case objects <- obj:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	removed := 0
+	var errs []error
+	for result := range s3.Client.RemoveObjectsWithResult(ctx, s3.BucketName, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete object %s: %w", result.ObjectName, result.Err))
+			continue
+		}
+		removed++
+	}
+	if err := ctx.Err(); err != nil {
+		return removed, err
+	}
+	// The objects are only closed once the listing is over.
+	select {
+	case err := <-listErr:
+		errs = append(errs, fmt.Errorf("failed to list objects under %s: %w", prefix, err))
+	default:
+	}
+
+	for upload := range s3.Client.ListIncompleteUploads(ctx, s3.BucketName, prefix, true) {
+		if upload.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to list uploads under %s: %w", prefix, upload.Err))
+			break
+		}
+		if err := s3.Client.RemoveIncompleteUpload(ctx, s3.BucketName, upload.Key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to abort upload %s: %w", upload.Key, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+func (s3 *Storage) PresignGet(ctx context.Context, object string, expires time.Duration) (string, error) {
+	u, err := s3.Client.PresignedGetObject(ctx, s3.BucketName, object, expires, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", object, err)
+	}
+	return u.String(), nil
 }
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ksamf/video-upscaling/backend/internal/config"
+)
+
+const (
+	DriverS3     = "s3"
+	DriverLocal  = "local"
+	DriverMemory = "memory"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+	// ErrNotSupported is returned by backends that can't do an operation,
+	// such as presigning part uploads without S3.
+	ErrNotSupported = errors.New("not supported by this storage")
+
+	errInvalidKey = errors.New("invalid object key")
+)
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+	CacheControl string
+	// Metadata has lower case keys. Listings may leave it empty.
+	Metadata map[string]string
+}
+
+// Metadata keys set on the objects of a video.
+const (
+	MetaVideoID   = "video-id"
+	MetaRendition = "rendition"
+	// MetaChecksum is the hex SHA-256 of the content, set when it is known
+	// before the upload.
+	MetaChecksum = "sha256"
+)
+
+// PutOptions describe an object being stored. Empty fields are inferred from
+// the key, see ContentType and CacheControl.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+func (o PutOptions) withDefaults(key string) PutOptions {
+	if o.ContentType == "" {
+		o.ContentType = ContentType(key)
+	}
+	if o.CacheControl == "" {
+		o.CacheControl = CacheControl(key)
+	}
+	return o
+}
+
+// VideoObject returns the options for an object of a video, of rendition if
+// it isn't empty.
+func VideoObject(videoID, rendition string) PutOptions {
+	meta := map[string]string{MetaVideoID: videoID}
+	if rendition != "" {
+		meta[MetaRendition] = rendition
+	}
+	return PutOptions{Metadata: meta}
+}
+
+// WithChecksum returns o with the checksum of the content added.
+func (o PutOptions) WithChecksum(sum string) PutOptions {
+	meta := maps.Clone(o.Metadata)
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	meta[MetaChecksum] = sum
+	o.Metadata = meta
+	return o
+}
+
+var contentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4s":  "video/iso.segment",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".vtt":  "text/vtt",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+}
+
+// ContentType returns the type of the artifact at key, by its extension.
+func ContentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// CacheControl returns how clients may cache the object at key. Manifests are
+// rewritten when renditions are added, so they are revalidated every time;
+// the media they point to can be kept for a day.
+func CacheControl(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8", ".mpd":
+		return "no-cache"
+	}
+	return "max-age=86400"
+}
+
+// Checksum returns the hex SHA-256 of the rest of r, and seeks back to where r
+// was.
+func Checksum(r io.ReadSeeker) (string, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to compute checksum: %w", err)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ObjectStore is where uploads and everything produced from them are kept.
+type ObjectStore interface {
+	// Put stores r under key; size is -1 if it isn't known.
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error
+	// Get opens key for reading, or returns ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Stat returns the info of key, or ErrNotFound.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes key. Removing a key that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns every object whose key starts with prefix, at any depth.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGet returns a URL that reads key without credentials until
+	// expires has passed. ffmpeg reads objects through it, too.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Multipart uploads an object in parts, for uploads too large to send at once
+// or that arrive over several requests.
+type Multipart interface {
+	NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error)
+	PutPart(ctx context.Context, key, uploadID string, number int, reader io.Reader, size int64) (Part, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	// PresignPart lets a client upload a part directly.
+	PresignPart(ctx context.Context, key, uploadID string, number int, expires time.Duration) (string, error)
+}
+
+// Store is the storage the backend runs on.
+type Store interface {
+	ObjectStore
+	Multipart
+	// RemovePrefix deletes every object under prefix, along with multipart
+	// uploads that were never completed, and returns the number of objects
+	// removed.
+	RemovePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// New opens the store selected by conf.Storage.Driver. The local and memory
+// stores serve their objects themselves, see FileServer.
+func New(conf *config.Config) (Store, error) {
+	switch conf.Storage.Driver {
+	case DriverS3, "":
+		return NewS3(conf)
+	case DriverLocal:
+		return NewLocal(conf.Storage.Dir, conf.Storage.PublicURL)
+	case DriverMemory:
+		return NewMemory(conf.Storage.PublicURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", conf.Storage.Driver)
+	}
+}
+
+// IsNotFound reports whether err means the object or bucket doesn't exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || isMinioNotFound(err)
+}
+
+func Exists(ctx context.Context, store ObjectStore, key string) (bool, error) {
+	_, err := store.Stat(ctx, key)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func ReadAll(ctx context.Context, store ObjectStore, key string) ([]byte, error) {
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// ReadRange reads up to length bytes of key starting at offset.
+func ReadRange(ctx context.Context, store ObjectStore, key string, offset, length int64) ([]byte, error) {
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	if _, err := obj.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek in %s: %w", key, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(obj, length))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Download copies key to a local file at path.
+func Download(ctx context.Context, store ObjectStore, key, path string) error {
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	localFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create tmp file: %w", err)
+	}
+	defer localFile.Close()
+
+	if _, err := io.Copy(localFile, obj); err != nil {
+		return fmt.Errorf("failed to copy %s to local file: %w", key, err)
+	}
+	if err := localFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync tmp file: %w", err)
+	}
+	return nil
+}
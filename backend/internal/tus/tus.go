@@ -0,0 +1,306 @@
+package tus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination"
+	PartSize   = 8 << 20
+
+	uploadTTL   = 24 * time.Hour
+	finishedTTL = time.Hour
+	lockTTL     = time.Hour
+)
+
+var (
+	ErrNotFound = errors.New("upload not found")
+	ErrLocked   = errors.New("upload is locked by another request")
+)
+
+type Upload struct {
+	ID          uuid.UUID         `json:"id"`
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Key         string            `json:"key"`
+	UploadID    string            `json:"upload_id"`
+	Parts       []storage.Part    `json:"parts"`
+	PendingSize int64             `json:"pending_size"`
+	Metadata    map[string]string `json:"metadata"`
+	// Assembled is set once the parts were completed into the object at Key.
+	Assembled bool      `json:"assembled"`
+	JobID     uuid.UUID `json:"job_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Received reports whether every byte of the upload was stored.
+func (u *Upload) Received() bool {
+	return u.Offset == u.Length
+}
+
+// Completed reports whether the upload was assembled and handed to a job, so
+// there is nothing left to do for it.
+func (u *Upload) Completed() bool {
+	return u.Assembled && u.JobID != uuid.Nil
+}
+
+func (u *Upload) pendingKey() string {
+	return u.Key + ".part"
+}
+
+type Store struct {
+	rdb *redis.Client
+	s3  storage.Store
+}
+
+func NewStore(rdb *redis.Client, s3 storage.Store) *Store {
+	return &Store{rdb: rdb, s3: s3}
+}
+
+func stateKey(id uuid.UUID) string {
+	return fmt.Sprintf("tus:%s", id)
+}
+
+func (s *Store) Create(ctx context.Context, id uuid.UUID, key string, length int64, metadata map[string]string) (*Upload, error) {
+	uploadID, err := s.s3.NewMultipartUpload(ctx, key, storage.VideoObject(id.String(), ""))
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{
+		ID:        id,
+		Length:    length,
+		Key:       key,
+		UploadID:  uploadID,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	}
+	if err := s.save(ctx, u, uploadTTL); err != nil {
+		_ = s.s3.AbortMultipartUpload(ctx, key, uploadID)
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *Store) Get(ctx context.Context, id uuid.UUID) (*Upload, error) {
+	data, err := s.rdb.Get(ctx, stateKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to load upload %s: %w", id, err)
+	}
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("failed to decode upload %s: %w", id, err)
+	}
+	return &u, nil
+}
+
+func (s *Store) save(ctx context.Context, u *Upload, ttl time.Duration) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload %s: %w", u.ID, err)
+	}
+	if err := s.rdb.Set(ctx, stateKey(u.ID), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save upload %s: %w", u.ID, err)
+	}
+	return nil
+}
+
+// unlockScript deletes a lock only if it still holds the token it was taken
+// with, so a request whose lock expired can't release the lock of the next.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lock guards an upload against concurrent PATCH/DELETE requests, which would
+// otherwise race on the part numbers and the offset.
+func (s *Store) Lock(ctx context.Context, id uuid.UUID) (func(), error) {
+	key := stateKey(id) + ":lock"
+	token := rand.Text()
+	ok, err := s.rdb.SetNX(ctx, key, token, lockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock upload %s: %w", id, err)
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+	return func() {
+		if err := unlockScript.Run(context.Background(), s.rdb, []string{key}, token).Err(); err != nil {
+			log.Printf("tus upload %s: failed to unlock: %v", id, err)
+		}
+	}, nil
+}
+
+// Write streams body into the multipart upload. Full parts go straight to S3;
+// a trailing chunk smaller than the S3 minimum part size is parked in a
+// temporary object and prepended to the data of the next PATCH. The offset is
+// only advanced for bytes that were persisted, so a dropped connection can be
+// resumed from the value reported by HEAD. Once every byte was received the
+// upload is assembled; if that fails, Write can be called again without a body
+// to retry it.
+func (s *Store) Write(ctx context.Context, u *Upload, body io.Reader) error {
+	buf := make([]byte, 0, PartSize)
+	if u.PendingSize > 0 {
+		pending, err := storage.ReadAll(ctx, s.s3, u.pendingKey())
+		if err != nil {
+			return err
+		}
+		if int64(len(pending)) != u.PendingSize {
+			return fmt.Errorf("pending chunk of upload %s has %d bytes, expected %d", u.ID, len(pending), u.PendingSize)
+		}
+		buf = append(buf, pending...)
+	}
+
+	body = io.LimitReader(body, u.Length-u.Offset)
+	var readErr error
+	for {
+		n, err := io.ReadFull(body, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if len(buf) == cap(buf) {
+			if err := s.flushPart(ctx, u, buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				readErr = err
+			}
+			break
+		}
+	}
+
+	if u.partsSize()+int64(len(buf)) == u.Length {
+		if len(buf) > 0 {
+			if err := s.flushPart(ctx, u, buf); err != nil {
+				return err
+			}
+		}
+		return s.Assemble(ctx, u)
+	}
+
+	if len(buf) > 0 {
+		if err := s.s3.Put(ctx, u.pendingKey(), bytes.NewReader(buf), int64(len(buf)), storage.PutOptions{}); err != nil {
+			return err
+		}
+		u.PendingSize = int64(len(buf))
+		u.Offset = u.partsSize() + u.PendingSize
+		if err := s.save(ctx, u, uploadTTL); err != nil {
+			return err
+		}
+	}
+	return readErr
+}
+
+func (s *Store) flushPart(ctx context.Context, u *Upload, data []byte) error {
+	part, err := s.s3.PutPart(ctx, u.Key, u.UploadID, len(u.Parts)+1, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	if u.PendingSize > 0 {
+		if err := s.s3.Delete(ctx, u.pendingKey()); err != nil {
+			log.Printf("tus upload %s: %v", u.ID, err)
+		}
+		u.PendingSize = 0
+	}
+	u.Parts = append(u.Parts, part)
+	u.Offset = u.partsSize()
+	return s.save(ctx, u, uploadTTL)
+}
+
+// Assemble completes the multipart upload of an upload that received every
+// byte. A multipart upload that was completed before the state could be saved
+// is recognised by its object, so Assemble can always be retried.
+func (s *Store) Assemble(ctx context.Context, u *Upload) error {
+	if u.Assembled {
+		return nil
+	}
+	if !u.Received() {
+		return fmt.Errorf("upload %s has %d of %d bytes", u.ID, u.Offset, u.Length)
+	}
+	if err := s.s3.CompleteMultipartUpload(ctx, u.Key, u.UploadID, u.Parts); err != nil {
+		info, serr := s.s3.Stat(ctx, u.Key)
+		if serr != nil || info.Size != u.Length {
+			return err
+		}
+	}
+	u.Assembled = true
+	return s.save(ctx, u, uploadTTL)
+}
+
+func (u *Upload) partsSize() int64 {
+	var size int64
+	for _, p := range u.Parts {
+		size += p.Size
+	}
+	return size
+}
+
+// Finish records the job created for an assembled upload so HEAD can still
+// report it for a while after the upload itself is done.
+func (s *Store) Finish(ctx context.Context, u *Upload, jobID uuid.UUID) error {
+	u.JobID = jobID
+	return s.save(ctx, u, finishedTTL)
+}
+
+func (s *Store) Terminate(ctx context.Context, u *Upload) error {
+	if !u.Assembled {
+		if err := s.s3.AbortMultipartUpload(ctx, u.Key, u.UploadID); err != nil {
+			return err
+		}
+	} else if u.JobID == uuid.Nil {
+		// Nothing uses the object yet.
+		if err := s.s3.Delete(ctx, u.Key); err != nil {
+			return err
+		}
+	}
+	if u.PendingSize > 0 {
+		if err := s.s3.Delete(ctx, u.pendingKey()); err != nil {
+			return err
+		}
+	}
+	if err := s.rdb.Del(ctx, stateKey(u.ID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete upload %s: %w", u.ID, err)
+	}
+	return nil
+}
+
+// ParseMetadata decodes an Upload-Metadata header: comma separated pairs of a
+// key and an optional base64 encoded value.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
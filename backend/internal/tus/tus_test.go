@@ -0,0 +1,197 @@
+package tus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+var errDropped = errors.New("connection dropped")
+
+func newTestStore(t *testing.T) (*Store, *storage.Memory) {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	s3 := storage.NewMemory("http://objects.test")
+	return NewStore(rdb, s3), s3
+}
+
+func createUpload(t *testing.T, s *Store, length int64) *Upload {
+	t.Helper()
+	id := uuid.New()
+	u, err := s.Create(context.Background(), id, id.String()+"/tmp.mp4", length, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestWrite(t *testing.T) {
+	const length = PartSize + 1000
+	type step struct {
+		// size bytes are sent from the current offset; a dropped request
+		// fails after them.
+		size    int
+		dropped bool
+
+		offset    int64
+		pending   int64
+		parts     int
+		assembled bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "one request",
+			steps: []step{
+				{size: length, offset: length, parts: 2, assembled: true},
+			},
+		},
+		{
+			name: "small chunks",
+			steps: []step{
+				{size: 500, offset: 500, pending: 500},
+				{size: 500, offset: 1000, pending: 1000},
+				{size: PartSize - 1000, offset: PartSize, parts: 1},
+				{size: 1000, offset: length, parts: 2, assembled: true},
+			},
+		},
+		{
+			name: "chunk crossing a part",
+			steps: []step{
+				{size: 600, offset: 600, pending: 600},
+				{size: length - 600, offset: length, parts: 2, assembled: true},
+			},
+		},
+		{
+			name: "dropped requests",
+			steps: []step{
+				{size: 300, dropped: true, offset: 300, pending: 300},
+				{size: PartSize, dropped: true, offset: PartSize + 300, pending: 300, parts: 1},
+				{size: 700, offset: length, parts: 2, assembled: true},
+			},
+		},
+		{
+			name: "empty request",
+			steps: []step{
+				{size: 0},
+				{size: length, offset: length, parts: 2, assembled: true},
+			},
+		},
+	}
+	data := make([]byte, length)
+	rand.Read(data)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, s3 := newTestStore(t)
+			ctx := context.Background()
+			u := createUpload(t, s, length)
+			for i, step := range tt.steps {
+				var body io.Reader = bytes.NewReader(data[u.Offset : u.Offset+int64(step.size)])
+				if step.dropped {
+					body = io.MultiReader(body, iotest.ErrReader(errDropped))
+				}
+				err := s.Write(ctx, u, body)
+				if step.dropped != errors.Is(err, errDropped) || (!step.dropped && err != nil) {
+					t.Fatalf("step %d: Write() error = %v", i, err)
+				}
+
+				// What a HEAD after the request would see.
+				saved, err := s.Get(ctx, u.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, got := range []*Upload{u, saved} {
+					if got.Offset != step.offset || got.PendingSize != step.pending || len(got.Parts) != step.parts || got.Assembled != step.assembled {
+						t.Fatalf("step %d: offset = %d, pending = %d, parts = %d, assembled = %v, want %d, %d, %d, %v",
+							i, got.Offset, got.PendingSize, len(got.Parts), got.Assembled, step.offset, step.pending, step.parts, step.assembled)
+					}
+				}
+			}
+
+			got, err := storage.ReadAll(ctx, s3, u.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("the assembled object differs from the data sent")
+			}
+			if ok, _ := storage.Exists(ctx, s3, u.pendingKey()); ok {
+				t.Error("the pending chunk was kept")
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	s, s3 := newTestStore(t)
+	ctx := context.Background()
+	data := []byte("a short video")
+	u := createUpload(t, s, int64(len(data)))
+
+	if err := s.Assemble(ctx, u); err == nil {
+		t.Fatal("Assemble() of an upload missing bytes succeeded")
+	}
+
+	// The multipart upload was completed, but the state saying so was lost.
+	part, err := s3.PutPart(ctx, u.Key, u.UploadID, 1, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Parts = []storage.Part{part}
+	u.Offset = u.Length
+	if err := s3.CompleteMultipartUpload(ctx, u.Key, u.UploadID, u.Parts); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Assemble(ctx, u); err != nil {
+		t.Fatalf("Assemble() again error = %v", err)
+	}
+	saved, err := s.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Assembled {
+		t.Error("the upload was not saved as assembled")
+	}
+}
+
+func TestLock(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	id := uuid.New()
+
+	unlock, err := s.Lock(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lock(ctx, id); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock() error = %v, want %v", err, ErrLocked)
+	}
+
+	// The lock expired and was taken by another request, whose lock must
+	// survive the first request releasing its own.
+	s.rdb.Del(ctx, stateKey(id)+":lock")
+	unlockNext, err := s.Lock(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := s.Lock(ctx, id); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock() after a stale unlock error = %v, want %v", err, ErrLocked)
+	}
+	unlockNext()
+	if _, err := s.Lock(ctx, id); err != nil {
+		t.Fatalf("Lock() after unlock error = %v", err)
+	}
+}
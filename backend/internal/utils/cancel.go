@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+var errJobCancelled = errors.New("job was cancelled")
+
+// runningJobs holds the cancel functions of the jobs this worker is running.
+type runningJobs struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]context.CancelCauseFunc
+}
+
+func newRunningJobs() *runningJobs {
+	return &runningJobs{jobs: map[uuid.UUID]context.CancelCauseFunc{}}
+}
+
+func (r *runningJobs) start(parent context.Context, jobID uuid.UUID) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	r.mu.Lock()
+	r.jobs[jobID] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.jobs, jobID)
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func (r *runningJobs) cancel(jobID uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.jobs[jobID]
+	if ok {
+		cancel(errJobCancelled)
+	}
+	return ok
+}
+
+// watchCancellations cancels local jobs named on the Redis cancel channel.
+// Every worker receives every message and ignores the jobs it doesn't hold.
+func watchCancellations(ctx context.Context, rdb *redis.Client, running *runningJobs) {
+	pubsub := rdb.Subscribe(ctx, cache.CancelChannel)
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	for msg := range pubsub.Channel() {
+		jobID, err := uuid.Parse(msg.Payload)
+		if err != nil {
+			continue
+		}
+		if running.cancel(jobID) {
+			log.Printf("Job %s: cancellation requested", jobID)
+		}
+	}
+}
+
+// CancelJob publishes the cancellation before marking the job, so a job
+// marked cancelled is never left running because the publish failed.
+func CancelJob(ctx context.Context, models database.Models, rdb *redis.Client, jobID uuid.UUID) error {
+	if err := cache.PublishCancel(ctx, rdb, jobID); err != nil {
+		return err
+	}
+	return models.Jobs.SetStatus(jobID, database.JobCancelled)
+}
+
+// CancelVideoJobs cancels every job of a video that hasn't finished.
+func CancelVideoJobs(ctx context.Context, models database.Models, rdb *redis.Client, videoID uuid.UUID) error {
+	jobs, err := models.Jobs.GetByVideoID(videoID)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if database.Finished(job.Status) {
+			continue
+		}
+		if err := CancelJob(ctx, models, rdb, job.JobId); err != nil {
+			return fmt.Errorf("failed to cancel job %s: %w", job.JobId, err)
+		}
+	}
+	return nil
+}
+
+// cleanupCancelled removes what a cancelled job wrote: its temporary files
+// and the renditions it left pending or failed, with their objects. The video
+// row and its source are kept, so the video can be processed again. If the
+// video is being deleted, which may be what cancelled the job, the deletion is
+// run once more, since the job may have written objects after it removed
+// them.
+func cleanupCancelled(models database.Models, deleter *Deleter, s3 storage.Store, job broker.VideoJob) error {
+	ctx := context.Background()
+	if err := os.RemoveAll(jobTempDir(job.JobID)); err != nil {
+		log.Printf("Job %s: %v", job.JobID, err)
+	}
+
+	deletion, err := models.Deletions.Get(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if deletion != nil {
+		if _, err := deleter.Delete(ctx, job.VideoID); err != nil {
+			return err
+		}
+		_, err := s3.RemovePrefix(ctx, job.VideoID.String()+"/")
+		return err
+	}
+
+	// The job only marks renditions pending once the source is probed.
+	steps, err := loadCheckpoints(models.Steps, job.JobID)
+	if err != nil {
+		return err
+	}
+	mediaInfo := &database.MediaInfo{}
+	if !steps.load(stepProbe, mediaInfo) {
+		return nil
+	}
+	heights := jobHeights(job, mediaInfo.Height)
+
+	renditions, err := models.Renditions.GetByVideoID(job.VideoID)
+	if err != nil {
+		return err
+	}
+	videoID := job.VideoID.String()
+	for _, r := range renditions {
+		if r.Status == database.RenditionReady || !slices.Contains(heights, r.Height) {
+			continue
+		}
+		if err := s3.Delete(ctx, RenditionKey(videoID, r.Height)); err != nil {
+			return err
+		}
+		if _, err := s3.RemovePrefix(ctx, fmt.Sprintf("%s/%d/", HlsPrefix(videoID), r.Height)); err != nil {
+			return err
+		}
+		if err := models.Renditions.Delete(job.VideoID, r.Height); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobHeights returns the heights of the renditions a job encodes for a source
+// of the given height.
+func jobHeights(job broker.VideoJob, height int) []int {
+	if !slices.Contains(StandardHeights, height) {
+		height = ClosestStandardHeight(height)
+	}
+	heights := append(LowerStandardRes(height), height)
+	if job.Upscale && height <= 1440 {
+		heights = append(heights, UpscaledHeights(height)...)
+	}
+	return heights
+}
+
+// jobTempDir is where a job keeps its temporary files. It is named after the
+// job, as several jobs of the same video may run at once.
+func jobTempDir(jobID uuid.UUID) string {
+	return filepath.Join(os.TempDir(), "job_"+jobID.String())
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+)
+
+const (
+	stepProbe     = "probe"
+	stepAudio     = "audio"
+	stepSubtitles = "subtitles"
+	stepUpscale   = "upscale"
+)
+
+// checkpoints tracks the finished steps of a job. A job delivered again after
+// a crash or a retry skips them and picks up where the last attempt stopped.
+type checkpoints struct {
+	steps database.StepModel
+	jobID uuid.UUID
+
+	mu   sync.Mutex
+	done map[string][]byte
+}
+
+func loadCheckpoints(steps database.StepModel, jobID uuid.UUID) (*checkpoints, error) {
+	done, err := steps.Completed(jobID)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoints{steps: steps, jobID: jobID, done: done}, nil
+}
+
+func (c *checkpoints) finished(step string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.done[step]
+	return ok
+}
+
+// load reads what step saved into v. It reports false if the step isn't
+// finished or its data can't be read, in which case the step has to run.
+func (c *checkpoints) load(step string, v any) bool {
+	c.mu.Lock()
+	data, ok := c.done[step]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("Job %s: unreadable checkpoint %s: %v", c.jobID, step, err)
+		return false
+	}
+	return true
+}
+
+// complete marks step as finished with v as its data. Failing to save a
+// checkpoint only costs redoing the step, so the error is just logged.
+func (c *checkpoints) complete(step string, v any) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = c.steps.Complete(c.jobID, step, data)
+	}
+	if err != nil {
+		log.Printf("Job %s: failed to save checkpoint %s: %v", c.jobID, step, err)
+		return
+	}
+	c.mu.Lock()
+	c.done[step] = data
+	c.mu.Unlock()
+}
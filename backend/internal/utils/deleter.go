@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+	"github.com/redis/go-redis/v9"
+)
+
+const deletionBatch = 10
+
+// Deleter removes videos with everything that belongs to them: their running
+// jobs, every object under their prefix, what is cached about them and their
+// rows. Each step can be run again, and a deletion records the step it got
+// to, so one that fails part-way is retried by Run until it completes. The
+// video.deleted event is recorded with the rows being deleted.
+type Deleter struct {
+	models database.Models
+	s3     storage.Store
+	rdb    *redis.Client
+	outbox *OutboxRelay
+}
+
+func NewDeleter(models database.Models, s3 storage.Store, rdb *redis.Client, outbox *OutboxRelay) *Deleter {
+	return &Deleter{models: models, s3: s3, rdb: rdb, outbox: outbox}
+}
+
+// Delete starts deleting a video and runs the deletion as far as it gets. It
+// returns nil if the video doesn't exist. A deletion that fails is returned
+// with its error and left for Run to finish.
+func (d *Deleter) Delete(ctx context.Context, videoID uuid.UUID) (*database.Deletion, error) {
+	deletion, err := d.models.Deletions.Start(videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start deleting video %s: %w", videoID, err)
+	}
+	if deletion == nil || deletion.Done() {
+		return deletion, nil
+	}
+	return deletion, d.run(ctx, deletion)
+}
+
+// Run retries unfinished deletions every interval until ctx is cancelled. A
+// deletion is only retried once interval has passed since its last attempt.
+func (d *Deleter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		deletions, err := d.models.Deletions.Claim(deletionBatch, interval)
+		if err != nil {
+			log.Printf("Deleter error: %v", err)
+			continue
+		}
+		for _, deletion := range deletions {
+			if err := d.run(ctx, deletion); err != nil {
+				log.Printf("Video %s: attempt %d: %v", deletion.VideoId, deletion.Attempts, err)
+			}
+		}
+	}
+}
+
+// run runs the remaining steps of deletion in order, advancing it after each.
+func (d *Deleter) run(ctx context.Context, deletion *database.Deletion) error {
+	for deletion.Step != database.DeleteDone {
+		if err := d.step(ctx, deletion.VideoId, deletion.Step); err != nil {
+			err = fmt.Errorf("failed to delete %s of video %s: %w", deletion.Step, deletion.VideoId, err)
+			if ferr := d.models.Deletions.Fail(deletion.VideoId, err); ferr != nil {
+				log.Printf("Video %s: failed to record deletion error: %v", deletion.VideoId, ferr)
+			}
+			return err
+		}
+		next := database.NextDeletionStep(deletion.Step)
+		// The last step marks the deletion done itself.
+		if next != database.DeleteDone {
+			if err := d.models.Deletions.Advance(deletion.VideoId, next); err != nil {
+				return fmt.Errorf("failed to record deletion of video %s: %w", deletion.VideoId, err)
+			}
+		}
+		deletion.Step = next
+	}
+	d.outbox.Notify()
+	return nil
+}
+
+func (d *Deleter) step(ctx context.Context, videoID uuid.UUID, step string) error {
+	switch step {
+	case database.DeleteJobs:
+		// Cancelled first, so no job is left writing objects for the video.
+		return CancelVideoJobs(ctx, d.models, d.rdb, videoID)
+	case database.DeleteObjects:
+		removed, err := d.s3.RemovePrefix(ctx, videoID.String()+"/")
+		if err != nil {
+			return err
+		}
+		log.Printf("Video %s: removed %d objects", videoID, removed)
+		return nil
+	case database.DeleteCache:
+		return cache.DeleteVideo(ctx, d.rdb, videoID)
+	case database.DeleteRecord:
+		msg, err := eventMessage(events.TypeVideoDeleted, videoID, events.VideoDeleted{})
+		if err != nil {
+			return err
+		}
+		return d.models.Deletions.Finish(videoID, msg)
+	default:
+		return fmt.Errorf("unknown step %q", step)
+	}
+}
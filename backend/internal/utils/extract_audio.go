@@ -4,21 +4,23 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
 	"github.com/ksamf/video-upscaling/backend/internal/storage"
 )
 
-func ExtractAudio(inputPath, fileName string, s3 *storage.Storage) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+// ExtractAudio uploads the sound of a video as mp3. It is only run for videos
+// with an audio stream, so ffmpeg failing means the stream can't be decoded.
+func ExtractAudio(ctx context.Context, inputPath, fileName string, s3 storage.ObjectStore, duration float64, progress func(float64)) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	tmpAudio := filepath.Join(os.TempDir(), fmt.Sprintf("%s_audio.mp3", fileName))
+	tmpAudio := filepath.Join(filepath.Dir(inputPath), "audio.mp3")
 	defer func() { _ = os.Remove(tmpAudio) }()
 
-	cmd := exec.CommandContext(ctx, "ffmpeg",
+	args := []string{
 		"-y",
 		"-i", inputPath,
 		"-vn",
@@ -26,20 +28,13 @@ func ExtractAudio(inputPath, fileName string, s3 *storage.Storage) error {
 		"-f", "mp3",
 		"-loglevel", "error",
 		tmpAudio,
-	)
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg audio extract failed: %w", err)
 	}
 
-	f, err := os.Open(tmpAudio)
-	if err != nil {
-		return fmt.Errorf("open extracted audio failed: %w", err)
+	if err := runFFmpeg(ctx, args, duration, progress); err != nil {
+		return retry.Permanent(fmt.Errorf("ffmpeg audio extract failed: %w", err))
 	}
-	defer f.Close()
 
-	if err := s3.PutObject(fmt.Sprintf("%s/audio.mp3", fileName), f); err != nil {
+	if err := putFile(ctx, s3, fmt.Sprintf("%s/audio.mp3", fileName), tmpAudio, storage.VideoObject(fileName, "")); err != nil {
 		return fmt.Errorf("s3 upload failed: %w", err)
 	}
 
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+)
+
+// priorityWeights is how many jobs each tier gets per round while every tier
+// has work waiting. Bulk jobs still get one in ten, so they keep moving even
+// when newsroom clips never stop coming in.
+var priorityWeights = map[string]int{
+	broker.PriorityHigh:   6,
+	broker.PriorityNormal: 3,
+	broker.PriorityBulk:   1,
+}
+
+// jobFeed merges the job topics into one stream. Every topic is fetched
+// independently, and whenever several have a message ready the next one is
+// picked by smooth weighted round robin over priorityWeights.
+type jobFeed struct {
+	topics  []string
+	weights map[string]int
+	fetched map[string]chan *broker.Delivery
+	wake    chan struct{}
+	// ready holds at most one message per topic, taken from fetched but not
+	// handed out yet.
+	ready  map[string]*broker.Delivery
+	credit map[string]int
+}
+
+func newJobFeed(ctx context.Context, consumers map[string]broker.Consumer) *jobFeed {
+	f := &jobFeed{
+		weights: map[string]int{},
+		fetched: map[string]chan *broker.Delivery{},
+		wake:    make(chan struct{}, 1),
+		ready:   map[string]*broker.Delivery{},
+		credit:  map[string]int{},
+	}
+	for _, priority := range broker.Priorities {
+		topic := broker.JobTopic(priority)
+		consumer, ok := consumers[topic]
+		if !ok {
+			continue
+		}
+		f.topics = append(f.topics, topic)
+		f.weights[topic] = priorityWeights[priority]
+		f.fetched[topic] = make(chan *broker.Delivery, 1)
+		go f.fetch(ctx, topic, consumer, f.fetched[topic])
+	}
+	return f
+}
+
+func (f *jobFeed) fetch(ctx context.Context, topic string, consumer broker.Consumer, out chan<- *broker.Delivery) {
+	for {
+		d, err := consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+				return
+			}
+			log.Printf("Broker read error on %s: %v", topic, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		select {
+		case out <- d:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// next blocks until a message is available on any topic or ctx is done.
+func (f *jobFeed) next(ctx context.Context) (*broker.Delivery, error) {
+	for {
+		for _, topic := range f.topics {
+			if _, ok := f.ready[topic]; ok {
+				continue
+			}
+			select {
+			case d := <-f.fetched[topic]:
+				f.ready[topic] = d
+			default:
+			}
+		}
+		if len(f.ready) > 0 {
+			topic := f.pick()
+			d := f.ready[topic]
+			delete(f.ready, topic)
+			return d, nil
+		}
+
+		select {
+		case <-f.wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pick runs one round of smooth weighted round robin over the topics that
+// have a message ready.
+func (f *jobFeed) pick() string {
+	best, total := "", 0
+	for _, topic := range f.topics {
+		if _, ok := f.ready[topic]; !ok {
+			continue
+		}
+		f.credit[topic] += f.weights[topic]
+		total += f.weights[topic]
+		if best == "" || f.credit[topic] > f.credit[best] {
+			best = topic
+		}
+	}
+	f.credit[best] -= total
+	return best
+}
@@ -2,39 +2,123 @@ package utils
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os/exec"
+	"math"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ksamf/video-upscaling/backend/internal/database"
 )
 
-type VideoInfo struct {
-	Streams []struct {
-		CodecType string `json:"codec_type"`
-		Width     int    `json:"width"`
-		Height    int    `json:"height"`
-	} `json:"streams"`
-}
+// GetMediaInfo probes the container and the first video and audio streams of
+// path. Cover art is skipped, so Width and Height are those of the real video.
+func GetMediaInfo(ctx context.Context, path string) (*database.MediaInfo, error) {
+	probe, err := Probe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &database.MediaInfo{
+		FormatName: probe.Format.FormatName,
+		Duration:   parseFloat(probe.Format.Duration),
+		Size:       parseInt(probe.Format.Size),
+		BitRate:    parseInt(probe.Format.BitRate),
+		Tags:       probe.Format.Tags,
+	}
+	if created, ok := probe.Format.Tags["creation_time"]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, created); err == nil {
+			info.CreationTime = &t
+		}
+	}
 
-func GetResolution(path string) (int, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	var video, audio *ProbeStream
+	for i := range probe.Streams {
+		s := &probe.Streams[i]
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic == 0 && video == nil:
+			video = s
+		case s.CodecType == "audio" && audio == nil:
+			audio = s
+		}
+	}
+	if video == nil {
+		return nil, fmt.Errorf("no video stream found")
+	}
 
-	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", "-loglevel", "error", path)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, 0, fmt.Errorf("ffprobe failed: %w", err)
+	info.Width = video.Width
+	info.Height = video.Height
+	info.VideoCodec = video.CodecName
+	info.VideoProfile = video.Profile
+	info.VideoBitRate = parseInt(video.BitRate)
+	info.FrameRate = parseFrameRate(video.AvgFrameRate)
+	if info.FrameRate == 0 {
+		info.FrameRate = parseFrameRate(video.RFrameRate)
 	}
+	info.PixelFormat = video.PixFmt
+	info.ColorPrimaries = video.ColorPrimaries
+	info.ColorTransfer = video.ColorTransfer
+	info.ColorSpace = video.ColorSpace
+	info.Rotation = streamRotation(video)
 
-	var vInfo VideoInfo
-	if err := json.Unmarshal(output, &vInfo); err != nil {
-		return 0, 0, fmt.Errorf("unmarshal failed: %w", err)
+	if audio != nil {
+		info.AudioCodec = audio.CodecName
+		info.AudioChannels = audio.Channels
+		info.AudioLayout = audio.ChannelLayout
+		info.AudioSampleRate = int(parseInt(audio.SampleRate))
+		info.AudioBitRate = parseInt(audio.BitRate)
 	}
+	return info, nil
+}
 
-	for _, s := range vInfo.Streams {
-		if s.CodecType == "video" {
-			return s.Width, s.Height, nil
+// streamRotation prefers the display matrix over the legacy rotate tag and
+// normalizes the result to 0, 90, 180 or 270 degrees clockwise.
+func streamRotation(s *ProbeStream) int {
+	rotation := 0.0
+	found := false
+	for _, sd := range s.SideDataList {
+		if sd.SideDataType == "Display Matrix" {
+			rotation = -sd.Rotation
+			found = true
+			break
+		}
+	}
+	if !found {
+		if tag, err := strconv.ParseFloat(s.Tags["rotate"], 64); err == nil {
+			rotation = tag
 		}
 	}
-	return 0, 0, fmt.Errorf("no video stream found")
+	degrees := int(math.Round(rotation)) % 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		return parseFloat(rate)
+	}
+	n, d := parseFloat(num), parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
+
+func parseFloat(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func parseInt(s string) int64 {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
 }
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ksamf/video-upscaling/backend/internal/manifest"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+func HlsPrefix(fileName string) string {
+	return fmt.Sprintf("%s/hls", fileName)
+}
+
+// PackageHLS splits an already keyframe-aligned mp4 into fMP4 HLS segments
+// without re-encoding, so every rendition shares the same segment boundaries.
+func PackageHLS(ctx context.Context, inputPath, outDir string, height int) (manifest.Rendition, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return manifest.Rendition{}, fmt.Errorf("failed to create hls dir: %w", err)
+	}
+
+	playlist := filepath.Join(outDir, manifest.MediaPlaylist)
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-map", "0",
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(manifest.SegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", manifest.InitSegment,
+		"-hls_segment_filename", filepath.Join(outDir, manifest.SegmentPattern),
+		"-loglevel", "error",
+		playlist,
+	}
+	if err := runFFmpeg(ctx, args, 0, nil); err != nil {
+		return manifest.Rendition{}, retry.Permanent(fmt.Errorf("ffmpeg hls packaging failed: %w", err))
+	}
+
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		return manifest.Rendition{}, fmt.Errorf("failed to read media playlist: %w", err)
+	}
+	segments, err := manifest.ParseMediaPlaylist(data)
+	if err != nil {
+		return manifest.Rendition{}, err
+	}
+	for i := range segments {
+		info, err := os.Stat(filepath.Join(outDir, segments[i].URI))
+		if err != nil {
+			return manifest.Rendition{}, fmt.Errorf("failed to stat segment: %w", err)
+		}
+		segments[i].Size = info.Size()
+	}
+
+	width, hasAudio, err := probeRendition(ctx, inputPath)
+	if err != nil {
+		return manifest.Rendition{}, err
+	}
+	rendition := manifest.Rendition{
+		ID:       strconv.Itoa(height),
+		Width:    width,
+		Height:   height,
+		Codecs:   manifest.Codecs(height, hasAudio),
+		Segments: segments,
+	}
+	rendition.ComputeBandwidth()
+	return rendition, nil
+}
+
+// uploadDashManifest writes the MPD next to the hls directory so it can
+// reference the same CMAF segments instead of storing media twice.
+func uploadDashManifest(ctx context.Context, fileName string, renditions []manifest.Rendition, s3 storage.ObjectStore) error {
+	mpd, err := manifest.BuildMPD(renditions, "hls/")
+	if err != nil {
+		return fmt.Errorf("failed to build dash manifest: %w", err)
+	}
+	key := fmt.Sprintf("%s/%s", fileName, manifest.DashManifest)
+	if err := putBytes(ctx, s3, key, mpd, storage.VideoObject(fileName, "")); err != nil {
+		return fmt.Errorf("failed to upload dash manifest: %w", err)
+	}
+	return nil
+}
+
+// UploadDir uploads every file under dir to prefix, all with opts.
+func UploadDir(ctx context.Context, dir, prefix string, s3 storage.ObjectStore, opts storage.PutOptions) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return putFile(ctx, s3, prefix+"/"+filepath.ToSlash(rel), path, opts)
+	})
+}
+
+func probeRendition(ctx context.Context, path string) (int, bool, error) {
+	probe, err := Probe(ctx, path)
+	if err != nil {
+		return 0, false, err
+	}
+	width, hasAudio := 0, false
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if width == 0 && s.Disposition.AttachedPic == 0 {
+				width = s.Width
+			}
+		case "audio":
+			hasAudio = true
+		}
+	}
+	if width == 0 {
+		return 0, false, fmt.Errorf("no video stream found")
+	}
+	return width, hasAudio, nil
+}
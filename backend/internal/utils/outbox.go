@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+)
+
+const (
+	outboxBatch = 100
+	// outboxRetention is how long sent messages are kept around for
+	// debugging before they are deleted.
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// OutboxRelay publishes the messages written to the outbox table. Every
+// message is published at least once: it is only marked sent after the broker
+// accepted it, so a crash in between publishes it again.
+type OutboxRelay struct {
+	outbox    database.OutboxModel
+	publisher broker.Publisher
+	interval  time.Duration
+	wake      chan struct{}
+}
+
+// NewOutboxRelay returns a relay that looks for new messages every interval,
+// or as soon as Notify is called.
+func NewOutboxRelay(outbox database.OutboxModel, publisher broker.Publisher, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:    outbox,
+		publisher: publisher,
+		interval:  interval,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify tells the relay that a message was just written, so it doesn't wait
+// for the next poll.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// OutboxMessage converts msg to be stored in the outbox.
+func OutboxMessage(msg broker.Message) *database.OutboxMessage {
+	return &database.OutboxMessage{
+		Topic:   msg.Topic,
+		Key:     msg.Key,
+		Payload: msg.Value,
+		Headers: msg.Headers,
+	}
+}
+
+// Emit records an event of eventType about videoID in the outbox, see
+// package events for the data of each type.
+func (r *OutboxRelay) Emit(eventType string, videoID uuid.UUID, data any) error {
+	msg, err := eventMessage(eventType, videoID, data)
+	if err != nil {
+		return err
+	}
+	if err := r.outbox.Add(msg); err != nil {
+		return fmt.Errorf("failed to record %s: %w", eventType, err)
+	}
+	r.Notify()
+	return nil
+}
+
+// eventMessage builds the outbox message of an event, for events that are
+// recorded in the same transaction as the change they announce.
+func eventMessage(eventType string, videoID uuid.UUID, data any) (*database.OutboxMessage, error) {
+	e, err := events.New(eventType, videoID, data)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := broker.EventMessage(e)
+	if err != nil {
+		return nil, err
+	}
+	return OutboxMessage(msg), nil
+}
+
+// emitEvent emits an event that isn't worth failing the work that led to it.
+func emitEvent(relay *OutboxRelay, eventType string, videoID uuid.UUID, data any) {
+	if err := relay.Emit(eventType, videoID, data); err != nil {
+		log.Printf("Video %s: %v", videoID, err)
+	}
+}
+
+// Flush publishes pending messages, a batch at a time, until none are left or
+// a batch fails.
+func (r *OutboxRelay) Flush(ctx context.Context) error {
+	for {
+		n, err := r.outbox.Dispatch(ctx, outboxBatch, func(pending []*database.OutboxMessage) error {
+			msgs := make([]broker.Message, 0, len(pending))
+			for _, msg := range pending {
+				msgs = append(msgs, broker.Message{
+					Topic:   msg.Topic,
+					Key:     msg.Key,
+					Value:   msg.Payload,
+					Headers: msg.Headers,
+				})
+			}
+			return r.publisher.Publish(ctx, msgs...)
+		})
+		if err != nil || n < outboxBatch {
+			return err
+		}
+	}
+}
+
+// Run flushes the outbox until ctx is cancelled. Sent messages are deleted
+// once they are older than a week.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		if err := r.Flush(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Outbox relay error: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		case <-cleanup.C:
+			if _, err := r.outbox.DeleteSent(outboxRetention); err != nil {
+				log.Printf("Outbox cleanup error: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,147 +1,336 @@
-package utils
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"path/filepath"
-	"slices"
-	"sync"
-	"time"
-
-	"github.com/ksamf/video-upscaling/backend/internal/database"
-	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
-	"github.com/ksamf/video-upscaling/backend/internal/rest"
-	"github.com/ksamf/video-upscaling/backend/internal/storage"
-)
-
-var StandardHeights = []int{144, 240, 360, 480, 720, 1080, 1440, 2160, 4320}
-
-func processVideoJob(job broker.VideoJob, db database.VideoModel, s3 *storage.Storage) error {
-	videoIDStr := job.VideoID.String()
-	s3Path := fmt.Sprintf("%s/tmp%s", videoIDStr, job.FileExt)
-	tmpInputPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s_input.%s", videoIDStr, job.FileExt))
-	defer os.Remove(tmpInputPath)
-	defer s3.DeleteObject(s3Path)
-	fmt.Println(s3Path)
-	if err := s3.GetObject(s3Path, tmpInputPath); err != nil {
-		return fmt.Errorf("failed to download from S3: %w", err)
-	}
-
-	_, height, err := GetResolution(tmpInputPath)
-	if err != nil {
-		return fmt.Errorf("failed get height:%w", err)
-	}
-	if !slices.Contains(StandardHeights, height) {
-		height = ClosestStandardHeight(height)
-		crf := 26 - 2*height
-		TranscodeVideo(tmpInputPath, height, crf, videoIDStr, s3, 30*time.Minute)
-
-	}
-	origKey := fmt.Sprintf("%s/%d.mp4", videoIDStr, height)
-	origFile, err := os.Open(tmpInputPath)
-	if err != nil {
-		return fmt.Errorf("failed to open original temp file: %w", err)
-	}
-	if err := s3.PutObject(origKey, origFile); err != nil {
-		_ = origFile.Close()
-		return fmt.Errorf("failed to upload original: %w", err)
-	}
-	_ = origFile.Close()
-	log.Printf("Uploaded original as %s", origKey)
-
-	qualities := LowerStandardRes(height)
-
-	errCh := make(chan error, len(qualities)+5)
-	var collected []error
-	var mu sync.Mutex
-	doneErr := make(chan struct{})
-
-	go func() {
-		for e := range errCh {
-			if e == nil {
-				continue
-			}
-			mu.Lock()
-			collected = append(collected, e)
-			mu.Unlock()
-			log.Printf("worker error: %v", e)
-		}
-		close(doneErr)
-	}()
-
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		if err := ExtractAudio(tmpInputPath, videoIDStr, s3); err != nil {
-			errCh <- fmt.Errorf("audio extract failed: %w", err)
-		}
-
-		lang, err := rest.CreateSubtitles(job.VideoID, job.BaseURL)
-		if err != nil {
-			errCh <- fmt.Errorf("create subtitles request failed: %w", err)
-			return
-		}
-		langId, err := db.GetLanguageId(lang)
-		if langId == 0 || err != nil {
-			errCh <- fmt.Errorf("db get language failed: %w", err)
-		}
-		if err := db.Insert(&database.Video{
-			VideoId:    job.VideoID,
-			Name:       job.FileName,
-			LanguageId: langId,
-			Quality:    height,
-		}); err != nil {
-			errCh <- fmt.Errorf("db insert failed: %w", err)
-			return
-		}
-	}()
-
-	for i, q := range qualities {
-		crf := 26 - 2*i
-		if crf < 8 {
-			crf = 8
-		}
-		if crf > 26 {
-			crf = 26
-		}
-
-		wg.Add(1)
-		go func(targetHeight, crf int) {
-			defer wg.Done()
-			if err := TranscodeVideo(tmpInputPath, targetHeight, crf, videoIDStr, s3, 30*time.Minute); err != nil {
-				errCh <- fmt.Errorf("transcode %dp failed: %w", targetHeight, err)
-			}
-		}(q, crf)
-	}
-
-	if height > 1440 {
-		job.Upscale = false
-	}
-	if job.Upscale {
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := rest.Upscale(job.VideoID, job.BaseURL, height, job.RealisticVideo); err != nil {
-				errCh <- fmt.Errorf("upscale failed: %w", err)
-			}
-		}()
-	}
-
-	wg.Wait()
-	close(errCh)
-	<-doneErr
-
-	if len(collected) > 0 {
-		for _, e := range collected {
-			log.Printf("processing error: %v", e)
-		}
-		return fmt.Errorf("some processing tasks failed")
-	}
-
-	return nil
-}
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/manifest"
+	"github.com/ksamf/video-upscaling/backend/internal/rest"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+)
+
+var StandardHeights = []int{144, 240, 360, 480, 720, 1080, 1440, 2160, 4320}
+
+// processVideoJob is safe to run again for the same job: every step that
+// finished on an earlier delivery is checkpointed and skipped, and the source
+// is only downloaded if an encode is still missing.
+func processVideoJob(ctx context.Context, job broker.VideoJob, models database.Models, s3 storage.ObjectStore, encoders *EncodeScheduler, outbox *OutboxRelay, tracker *progressTracker) error {
+	// Aborted with database.ErrVideoNotFound once the video turns out to be
+	// deleted, which stops every encode still running.
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	db := models.Videos
+	videoIDStr := job.VideoID.String()
+	s3Path := job.SourceKey
+	if s3Path == "" {
+		s3Path = fmt.Sprintf("%s/tmp%s", videoIDStr, job.FileExt)
+	}
+	steps, err := loadCheckpoints(models.Steps, job.JobID)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoints: %w", err)
+	}
+	workDir := jobTempDir(job.JobID)
+	if err := os.MkdirAll(workDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	tmpInputPath := filepath.Join(workDir, "input"+job.FileExt)
+	download := func() error {
+		tracker.setStatus(database.JobDownloading)
+		if err := getFile(ctx, s3, s3Path, tmpInputPath); err != nil {
+			return fmt.Errorf("failed to download from S3: %w", err)
+		}
+		return nil
+	}
+
+	mediaInfo := &database.MediaInfo{}
+	downloaded := false
+	if !steps.load(stepProbe, mediaInfo) {
+		if err := download(); err != nil {
+			return err
+		}
+		downloaded = true
+		mediaInfo, err = GetMediaInfo(ctx, tmpInputPath)
+		if err != nil {
+			return fmt.Errorf("failed get media info:%w", err)
+		}
+		if mediaInfo.Duration > 0 {
+			steps.complete(stepProbe, mediaInfo)
+		}
+	}
+	height, duration := mediaInfo.Height, mediaInfo.Duration
+	if duration <= 0 {
+		return fmt.Errorf("failed get duration: invalid duration %v", duration)
+	}
+	if !slices.Contains(StandardHeights, height) {
+		height = ClosestStandardHeight(height)
+	}
+
+	ladder := append(LowerStandardRes(height), height)
+	needSource := !steps.finished(stepAudio)
+	tracker.addTasks(stepAudio, stepSubtitles)
+	for _, q := range ladder {
+		tracker.addTasks(renditionTask(q))
+		if steps.load(renditionTask(q), &manifest.Rendition{}) {
+			continue
+		}
+		needSource = true
+		pendingRendition(models.Renditions, job.VideoID, q, renditionSource(q, height))
+	}
+	if needSource && !downloaded {
+		if err := download(); err != nil {
+			return err
+		}
+	}
+	tracker.setStatus(database.JobTranscoding)
+
+	var renditions []manifest.Rendition
+	sourceReady := make(chan bool, 1)
+	errCh := make(chan error, len(ladder)+5)
+	var collected []error
+	var mu sync.Mutex
+	doneErr := make(chan struct{})
+
+	go func() {
+		for e := range errCh {
+			if e == nil {
+				continue
+			}
+			mu.Lock()
+			collected = append(collected, e)
+			mu.Unlock()
+			log.Printf("worker error: %v", e)
+		}
+		close(doneErr)
+	}()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// A video without sound has nothing to extract or transcribe.
+		hasAudio := mediaInfo.AudioCodec != ""
+		if !hasAudio || steps.finished(stepAudio) {
+			tracker.set(stepAudio, 1)
+		} else {
+			err := encoders.Run(ctx, job.JobID, 1, func() error {
+				return ExtractAudio(ctx, tmpInputPath, videoIDStr, s3, duration, tracker.task(stepAudio))
+			})
+			if err != nil {
+				errCh <- fmt.Errorf("audio extract failed: %w", err)
+			} else {
+				steps.complete(stepAudio, nil)
+			}
+		}
+
+		if steps.finished(stepSubtitles) {
+			tracker.set(stepSubtitles, 1)
+			return
+		}
+		var lang string
+		var langId int
+		if hasAudio {
+			tracker.setStatus(database.JobSubtitling)
+			err := retry.Do(ctx, stepRetry, func() error {
+				var err error
+				lang, err = rest.CreateSubtitles(ctx, job.VideoID, job.BaseURL)
+				return err
+			})
+			if err != nil {
+				errCh <- fmt.Errorf("create subtitles request failed: %w", err)
+				return
+			}
+			langId, err = db.GetLanguageId(lang)
+			if langId == 0 || err != nil {
+				errCh <- fmt.Errorf("db get language failed: %w", err)
+			}
+		}
+		tracker.set(stepSubtitles, 1)
+		if err := db.SetProcessed(job.VideoID, langId, height); err != nil {
+			if errors.Is(err, database.ErrVideoNotFound) {
+				abort(err)
+			}
+			errCh <- fmt.Errorf("db update failed: %w", err)
+			return
+		}
+		if err := models.Metadata.Upsert(job.VideoID, mediaInfo); err != nil {
+			errCh <- fmt.Errorf("db insert metadata failed: %w", err)
+			return
+		}
+		if hasAudio {
+			emitEvent(outbox, events.TypeSubtitlesReady, job.VideoID, events.SubtitlesReady{
+				Language: lang,
+				Key:      SubtitlesKey(videoIDStr, lang),
+			})
+		}
+		steps.complete(stepSubtitles, nil)
+	}()
+
+	for i, q := range ladder {
+		crf := 26 - 2*i
+		if crf < 8 {
+			crf = 8
+		}
+		if crf > 26 {
+			crf = 26
+		}
+
+		wg.Add(1)
+		go func(targetHeight, crf int) {
+			defer wg.Done()
+			var rendition manifest.Rendition
+			if steps.load(renditionTask(targetHeight), &rendition) {
+				tracker.set(renditionTask(targetHeight), 1)
+				if targetHeight == height {
+					sourceReady <- true
+				}
+				mu.Lock()
+				renditions = append(renditions, rendition)
+				mu.Unlock()
+				return
+			}
+			// The slot covers packaging and upload as well, which also bounds
+			// the temporary files on disk.
+			err := encoders.Run(ctx, job.JobID, EncodeWeight(targetHeight), func() error {
+				var err error
+				rendition, err = TranscodeVideo(ctx, tmpInputPath, targetHeight, crf, videoIDStr, s3, 30*time.Minute, duration, tracker.task(renditionTask(targetHeight)))
+				return err
+			})
+			if targetHeight == height {
+				sourceReady <- err == nil
+			}
+			if err != nil {
+				failRendition(ctx, models.Renditions, job.VideoID, targetHeight)
+				errCh <- fmt.Errorf("transcode %dp failed: %w", targetHeight, err)
+				return
+			}
+			if err := recordRendition(ctx, models.Renditions, s3, job.VideoID, targetHeight, renditionSource(targetHeight, height)); err != nil {
+				failRendition(ctx, models.Renditions, job.VideoID, targetHeight)
+				errCh <- fmt.Errorf("record %dp rendition failed: %w", targetHeight, err)
+			} else {
+				emitRenditionReady(outbox, job, targetHeight, renditionSource(targetHeight, height))
+				steps.complete(renditionTask(targetHeight), rendition)
+			}
+			mu.Lock()
+			renditions = append(renditions, rendition)
+			mu.Unlock()
+		}(q, crf)
+	}
+
+	if height > 1440 {
+		job.Upscale = false
+	}
+	if job.Upscale && steps.finished(stepUpscale) {
+		tracker.addTasks(stepUpscale)
+		tracker.set(stepUpscale, 1)
+	} else if job.Upscale {
+		tracker.addTasks(stepUpscale)
+		upscaled := UpscaledHeights(height)
+		for _, q := range upscaled {
+			pendingRendition(models.Renditions, job.VideoID, q, database.RenditionUpscale)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failUpscaled := func() {
+				for _, q := range upscaled {
+					failRendition(ctx, models.Renditions, job.VideoID, q)
+				}
+			}
+			if !<-sourceReady {
+				failUpscaled()
+				errCh <- fmt.Errorf("upscale skipped: %dp rendition is missing", height)
+				return
+			}
+			tracker.setStatus(database.JobUpscaling)
+			err := retry.Do(ctx, stepRetry, func() error {
+				return rest.Upscale(ctx, job.VideoID, job.BaseURL, height, job.RealisticVideo)
+			})
+			if err != nil {
+				failUpscaled()
+				errCh <- fmt.Errorf("upscale failed: %w", err)
+				return
+			}
+			recorded := true
+			for _, q := range upscaled {
+				if err := recordRendition(ctx, models.Renditions, s3, job.VideoID, q, database.RenditionUpscale); err != nil {
+					failRendition(ctx, models.Renditions, job.VideoID, q)
+					errCh <- fmt.Errorf("record upscaled %dp rendition failed: %w", q, err)
+					recorded = false
+					continue
+				}
+				emitRenditionReady(outbox, job, q, database.RenditionUpscale)
+			}
+			if recorded {
+				steps.complete(stepUpscale, nil)
+			}
+			tracker.set(stepUpscale, 1)
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+	<-doneErr
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	if len(renditions) > 0 {
+		masterKey := fmt.Sprintf("%s/%s", HlsPrefix(videoIDStr), manifest.MasterPlaylist)
+		if err := putBytes(ctx, s3, masterKey, manifest.BuildMasterPlaylist(renditions), storage.VideoObject(videoIDStr, "")); err != nil {
+			collected = append(collected, fmt.Errorf("failed to upload master playlist: %w", err))
+		}
+		if err := uploadDashManifest(ctx, videoIDStr, renditions, s3); err != nil {
+			collected = append(collected, err)
+		}
+	}
+
+	if len(collected) > 0 {
+		for _, e := range collected {
+			log.Printf("processing error: %v", e)
+		}
+		return fmt.Errorf("some processing tasks failed: %w", errors.Join(collected...))
+	}
+
+	if job.SourceKey == "" {
+		if err := s3.Delete(ctx, s3Path); err != nil {
+			log.Printf("Job %s: failed to delete source %s: %v", job.JobID, s3Path, err)
+		}
+	}
+	return nil
+}
+
+func renditionSource(height, sourceHeight int) string {
+	if height == sourceHeight {
+		return database.RenditionOriginal
+	}
+	return database.RenditionTranscode
+}
+
+func emitRenditionReady(outbox *OutboxRelay, job broker.VideoJob, height int, source string) {
+	emitEvent(outbox, events.TypeRenditionReady, job.VideoID, events.RenditionReady{
+		JobID:  job.JobID,
+		Height: height,
+		Source: source,
+		Key:    RenditionKey(job.VideoID.String(), height),
+	})
+}
+
+func renditionTask(height int) string {
+	return fmt.Sprintf("%dp", height)
+}
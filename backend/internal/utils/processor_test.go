@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	"github.com/ksamf/video-upscaling/backend/internal/manifest"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+	"github.com/redis/go-redis/v9"
+)
+
+// testModels returns models on the database in TEST_DATABASE_URL, migrated
+// to the latest version. The test is skipped without one.
+func testModels(t *testing.T) database.Models {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	migrator, err := migrate.New("file://../../migrations", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	if err := migrator.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return database.NewModel(pool)
+}
+
+// testVideo encodes a short silent test pattern of the given height.
+func testVideo(t *testing.T, height int) string {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	name := filepath.Join(t.TempDir(), "input.mp4")
+	out, err := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc=duration=3:size=320x%d:rate=25", height),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", name).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ffmpeg: %v: %s", err, out)
+	}
+	return name
+}
+
+func TestProcessVideoJob(t *testing.T) {
+	models := testModels(t)
+	input := testVideo(t, 240)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	s3 := storage.NewMemory("http://objects.test")
+	b := broker.NewMemory()
+	t.Cleanup(func() { b.Close() })
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	outbox := NewOutboxRelay(models.Outbox, b, time.Second)
+	eventsConsumer, err := b.Subscribe(events.Topic, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := broker.VideoJob{JobID: uuid.New(), VideoID: uuid.New(), FileName: "test.mp4", FileExt: ".mp4"}
+	videoID := job.VideoID.String()
+	err = models.Jobs.Enqueue(
+		&database.Video{VideoId: job.VideoID, Name: job.FileName, Visibility: database.VisibilityPublic},
+		&database.Job{JobId: job.JobID, VideoId: job.VideoID},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { models.Videos.Delete(job.VideoID) })
+	source := videoID + "/tmp.mp4"
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.Put(ctx, source, bytes.NewReader(data), int64(len(data)), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := newProgressTracker(rdb, models.Jobs, job.VideoID, job.JobID, 1)
+	if err := processVideoJob(ctx, job, models, s3, NewEncodeScheduler(2), outbox, tracker); err != nil {
+		t.Fatalf("processVideoJob() error = %v", err)
+	}
+
+	ready, err := models.Renditions.GetReady(job.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heights []int
+	for _, r := range ready {
+		heights = append(heights, r.Height)
+	}
+	slices.Sort(heights)
+	if !slices.Equal(heights, []int{144, 240}) {
+		t.Errorf("ready renditions = %v, want [144 240]", heights)
+	}
+	for _, key := range []string{RenditionKey(videoID, 144), RenditionKey(videoID, 240), HlsPrefix(videoID) + "/" + manifest.MasterPlaylist} {
+		if ok, err := storage.Exists(ctx, s3, key); !ok || err != nil {
+			t.Errorf("%s is missing: %v", key, err)
+		}
+	}
+	if ok, _ := storage.Exists(ctx, s3, source); ok {
+		t.Error("the source was kept")
+	}
+	if _, err := os.Stat(jobTempDir(job.JobID)); !os.IsNotExist(err) {
+		t.Errorf("the temp dir was kept: %v", err)
+	}
+
+	if err := outbox.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var readyEvents []int
+	for len(readyEvents) < 2 {
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Second)
+		d, err := eventsConsumer.Fetch(fetchCtx)
+		cancel()
+		if err != nil {
+			t.Fatalf("got rendition.ready for %v, then %v", readyEvents, err)
+		}
+		var e events.Event
+		if err := json.Unmarshal(d.Value, &e); err != nil {
+			t.Fatal(err)
+		}
+		d.Ack(ctx)
+		if e.VideoID != job.VideoID || e.Type != events.TypeRenditionReady {
+			continue
+		}
+		var data events.RenditionReady
+		if err := json.Unmarshal(e.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		readyEvents = append(readyEvents, data.Height)
+	}
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/redis/go-redis/v9"
+)
+
+const progressInterval = time.Second
+
+// runFFmpeg runs ffmpeg with machine-readable progress on stdout and reports
+// the processed fraction of duration (in seconds) to onProgress.
+func runFFmpeg(ctx context.Context, args []string, duration float64, onProgress func(float64)) error {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open ffmpeg stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if onProgress == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || duration <= 0 {
+				continue
+			}
+			onProgress(min(float64(us)/1e6/duration, 1))
+		case "progress":
+			if value == "end" {
+				onProgress(1)
+			}
+		}
+	}
+
+	return cmd.Wait()
+}
+
+type progressTracker struct {
+	mu        sync.Mutex
+	rdb       *redis.Client
+	jobs      database.JobModel
+	state     cache.Progress
+	published time.Time
+}
+
+func newProgressTracker(rdb *redis.Client, jobs database.JobModel, videoID, jobID uuid.UUID, attempt int) *progressTracker {
+	return &progressTracker{
+		rdb:  rdb,
+		jobs: jobs,
+		state: cache.Progress{
+			VideoID: videoID,
+			JobID:   jobID,
+			Status:  database.JobQueued,
+			Tasks:   map[string]float64{},
+			Attempt: attempt,
+		},
+	}
+}
+
+func (t *progressTracker) addTasks(names ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range names {
+		if _, ok := t.state.Tasks[name]; !ok {
+			t.state.Tasks[name] = 0
+		}
+	}
+	t.publishLocked(true)
+}
+
+func (t *progressTracker) task(name string) func(float64) {
+	return func(fraction float64) {
+		t.set(name, fraction)
+	}
+}
+
+func (t *progressTracker) set(name string, fraction float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev := t.state.Tasks[name]
+	if fraction <= prev {
+		return
+	}
+	t.state.Tasks[name] = fraction
+	t.publishLocked(fraction == 1 || fraction-prev >= 0.05)
+}
+
+func (t *progressTracker) setStatus(status string) {
+	setJobStatus(t.jobs, t.state.JobID, status)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Status = status
+	if status == database.JobDone {
+		for name := range t.state.Tasks {
+			t.state.Tasks[name] = 1
+		}
+	}
+	t.publishLocked(true)
+}
+
+func (t *progressTracker) fail(jobErr error) {
+	if err := t.jobs.Fail(t.state.JobID, jobErr); err != nil {
+		log.Printf("Job %s: failed to save status: %v", t.state.JobID, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Status = database.JobFailed
+	t.state.Error = jobErr.Error()
+	t.publishLocked(true)
+}
+
+// cancelled only publishes the final state; the API has already marked the
+// job as cancelled in the database.
+func (t *progressTracker) cancelled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Status = database.JobCancelled
+	t.publishLocked(true)
+}
+
+// retry reports that the job failed but was queued again as attempt.
+func (t *progressTracker) retry(jobErr error, attempt int) {
+	if err := t.jobs.Retry(t.state.JobID, attempt, jobErr); err != nil {
+		log.Printf("Job %s: failed to save status: %v", t.state.JobID, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Status = database.JobQueued
+	t.state.Error = jobErr.Error()
+	t.state.Attempt = attempt
+	t.publishLocked(true)
+}
+
+func (t *progressTracker) publishLocked(force bool) {
+	now := time.Now()
+	if !force && now.Sub(t.published) < progressInterval {
+		return
+	}
+	t.published = now
+
+	var total float64
+	for _, fraction := range t.state.Tasks {
+		total += fraction
+	}
+	t.state.Percent = 0
+	if len(t.state.Tasks) > 0 {
+		t.state.Percent = total / float64(len(t.state.Tasks)) * 100
+	}
+	t.state.UpdatedAt = now
+
+	if t.rdb == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := cache.PublishProgress(ctx, t.rdb, t.state); err != nil {
+		log.Printf("Job %s: %v", t.state.JobID, err)
+	}
+}
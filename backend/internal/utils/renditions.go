@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ksamf/video-upscaling/backend/internal/database"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+func RenditionKey(fileName string, height int) string {
+	return fmt.Sprintf("%s/%d.mp4", fileName, height)
+}
+
+// SubtitlesKey is where the processor service stores the subtitles of a
+// video in lang.
+func SubtitlesKey(fileName, lang string) string {
+	return fmt.Sprintf("%s/%s_sub.vtt", fileName, lang)
+}
+
+// UpscaledHeights mirrors the processor service: it writes a rendition two
+// standard steps above height and one transcoded a single step above it.
+func UpscaledHeights(height int) []int {
+	for i, h := range StandardHeights {
+		if h == height {
+			last := len(StandardHeights) - 1
+			return []int{StandardHeights[min(i+1, last)], StandardHeights[min(i+2, last)]}
+		}
+	}
+	return []int{height * 2}
+}
+
+func pendingRendition(renditions database.RenditionModel, videoID uuid.UUID, height int, source string) {
+	err := renditions.Pending(&database.Rendition{
+		VideoId: videoID,
+		Height:  height,
+		S3Key:   RenditionKey(videoID.String(), height),
+		Source:  source,
+		Status:  database.RenditionPending,
+	})
+	if err != nil {
+		log.Printf("rendition %s/%d: %v", videoID, height, err)
+	}
+}
+
+// failRendition marks a rendition failed, unless its job was stopped: the
+// renditions of a cancelled job are cleaned up, those of an interrupted one
+// are encoded again.
+func failRendition(ctx context.Context, renditions database.RenditionModel, videoID uuid.UUID, height int) {
+	if ctx.Err() != nil {
+		return
+	}
+	if err := renditions.SetStatus(videoID, height, database.RenditionFailed); err != nil {
+		log.Printf("rendition %s/%d: %v", videoID, height, err)
+	}
+}
+
+// recordRendition probes the uploaded object itself, so a rendition is only
+// marked ready once it can actually be read back from S3.
+func recordRendition(ctx context.Context, renditions database.RenditionModel, s3 storage.ObjectStore, videoID uuid.UUID, height int, source string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	key := RenditionKey(videoID.String(), height)
+	url, err := s3.PresignGet(ctx, key, 15*time.Minute)
+	if err != nil {
+		return err
+	}
+	info, err := GetMediaInfo(ctx, url)
+	if err != nil {
+		return fmt.Errorf("rendition %s is not readable: %w", key, err)
+	}
+	return renditions.Upsert(&database.Rendition{
+		VideoId: videoID,
+		Height:  height,
+		Width:   info.Width,
+		Codec:   info.VideoCodec,
+		Bitrate: info.BitRate,
+		Size:    info.Size,
+		S3Key:   key,
+		Source:  source,
+		Status:  database.RenditionReady,
+	})
+}
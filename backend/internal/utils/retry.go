@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
+	"github.com/ksamf/video-upscaling/backend/internal/storage"
+)
+
+// stepRetry covers transient failures inside a job, such as an S3 hiccup or
+// a processor restart, so they don't cost a full re-run of the job.
+var stepRetry = retry.Policy{Attempts: 4, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second}
+
+// putFile uploads the file at path with its size and checksum.
+func putFile(ctx context.Context, s3 storage.ObjectStore, key, path string, opts storage.PutOptions) error {
+	return retry.Do(ctx, stepRetry, func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("failed to open %s: %w", path, err))
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("failed to stat %s: %w", path, err))
+		}
+		sum, err := storage.Checksum(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return s3.Put(ctx, key, f, info.Size(), opts.WithChecksum(sum))
+	})
+}
+
+func putBytes(ctx context.Context, s3 storage.ObjectStore, key string, data []byte, opts storage.PutOptions) error {
+	sum := sha256.Sum256(data)
+	opts = opts.WithChecksum(hex.EncodeToString(sum[:]))
+	return retry.Do(ctx, stepRetry, func() error {
+		return s3.Put(ctx, key, bytes.NewReader(data), int64(len(data)), opts)
+	})
+}
+
+func getFile(ctx context.Context, s3 storage.ObjectStore, key, path string) error {
+	return retry.Do(ctx, stepRetry, func() error {
+		err := storage.Download(ctx, s3, key, path)
+		if storage.IsNotFound(err) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+}
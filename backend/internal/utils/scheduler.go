@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// EncodeWeight is the share of the ffmpeg capacity an encode to height takes,
+// scaled by output pixels so that everything up to 720p costs 1, 1080p 3 and
+// 2160p 9.
+func EncodeWeight(height int) int {
+	const base = 720 * 720
+	return max(1, (height*height+base-1)/base)
+}
+
+// EncodeScheduler bounds the ffmpeg work a worker runs at once by the sum of
+// the weights of the running encodes. Capacity is shared fairly between jobs:
+// the next encode to start always belongs to the job that holds the least
+// capacity right now, so one large upload can't keep small ones waiting.
+type EncodeScheduler struct {
+	capacity int
+
+	mu      sync.Mutex
+	used    int
+	held    map[uuid.UUID]int
+	waiting []*encodeRequest
+}
+
+type encodeRequest struct {
+	job    uuid.UUID
+	weight int
+	ready  chan struct{}
+}
+
+// NewEncodeScheduler returns a scheduler for capacity weight units, or one per
+// CPU if capacity is not positive.
+func NewEncodeScheduler(capacity int) *EncodeScheduler {
+	if capacity <= 0 {
+		capacity = runtime.NumCPU()
+	}
+	return &EncodeScheduler{capacity: capacity, held: map[uuid.UUID]int{}}
+}
+
+// Acquire blocks until weight units are free for job and returns the function
+// that gives them back. Weights above the capacity are clamped to it, so a
+// large encode runs alone instead of never running at all.
+func (s *EncodeScheduler) Acquire(ctx context.Context, job uuid.UUID, weight int) (func(), error) {
+	req := &encodeRequest{
+		job:    job,
+		weight: min(max(weight, 1), s.capacity),
+		ready:  make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.waiting = append(s.waiting, req)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-req.ready:
+		var once sync.Once
+		return func() { once.Do(func() { s.release(req) }) }, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-req.ready:
+		s.releaseLocked(req)
+	default:
+		s.waiting = slices.DeleteFunc(s.waiting, func(r *encodeRequest) bool { return r == req })
+		s.dispatch()
+	}
+	return nil, ctx.Err()
+}
+
+// Run calls fn while holding weight units for job.
+func (s *EncodeScheduler) Run(ctx context.Context, job uuid.UUID, weight int, fn func() error) error {
+	release, err := s.Acquire(ctx, job, weight)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+func (s *EncodeScheduler) release(req *encodeRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(req)
+}
+
+func (s *EncodeScheduler) releaseLocked(req *encodeRequest) {
+	s.used -= req.weight
+	s.held[req.job] -= req.weight
+	if s.held[req.job] <= 0 {
+		delete(s.held, req.job)
+	}
+	s.dispatch()
+}
+
+// dispatch starts waiting requests while they fit. It picks the oldest request
+// of the job holding the least capacity and grants nothing past it until it
+// fits, so heavy encodes aren't starved by a stream of light ones.
+func (s *EncodeScheduler) dispatch() {
+	for len(s.waiting) > 0 {
+		next := 0
+		for i, req := range s.waiting {
+			if s.held[req.job] < s.held[s.waiting[next].job] {
+				next = i
+			}
+		}
+		req := s.waiting[next]
+		if s.used+req.weight > s.capacity {
+			return
+		}
+		s.waiting = slices.Delete(s.waiting, next, next+1)
+		s.used += req.weight
+		s.held[req.job] += req.weight
+		close(req.ready)
+	}
+}
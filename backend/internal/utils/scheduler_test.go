@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// acquire asks for weight units for job in the background and sends the
+// release function once they are granted.
+func acquire(t *testing.T, s *EncodeScheduler, job uuid.UUID, weight int) <-chan func() {
+	t.Helper()
+	granted := make(chan func(), 1)
+	go func() {
+		release, err := s.Acquire(context.Background(), job, weight)
+		if err != nil {
+			t.Errorf("Acquire() error = %v", err)
+			return
+		}
+		granted <- release
+	}()
+	return granted
+}
+
+func waitGranted(t *testing.T, granted <-chan func(), what string) func() {
+	t.Helper()
+	select {
+	case release := <-granted:
+		return release
+	case <-time.After(time.Second):
+		t.Fatalf("%s was not granted", what)
+		return nil
+	}
+}
+
+func notGranted(t *testing.T, granted <-chan func(), what string) {
+	t.Helper()
+	select {
+	case <-granted:
+		t.Fatalf("%s was granted", what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEncodeSchedulerClampsWeight(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		weight   int
+		held     int
+	}{
+		{name: "above capacity", capacity: 2, weight: EncodeWeight(2160), held: 2},
+		{name: "at capacity", capacity: 3, weight: 3, held: 3},
+		{name: "zero", capacity: 2, weight: 0, held: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEncodeScheduler(tt.capacity)
+			job := uuid.New()
+			release := waitGranted(t, acquire(t, s, job, tt.weight), "the encode")
+			s.mu.Lock()
+			used, held := s.used, s.held[job]
+			s.mu.Unlock()
+			if used != tt.held || held != tt.held {
+				t.Errorf("used = %d, held = %d, want %d", used, held, tt.held)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := s.Acquire(ctx, uuid.New(), tt.capacity-tt.held+1); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Acquire() past capacity error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			release()
+			waitGranted(t, acquire(t, s, uuid.New(), tt.capacity), "the encode after release")()
+		})
+	}
+}
+
+func TestEncodeSchedulerDoesNotStarveLargeEncodes(t *testing.T) {
+	s := NewEncodeScheduler(4)
+	small, other, large := uuid.New(), uuid.New(), uuid.New()
+
+	release := waitGranted(t, acquire(t, s, small, 1), "the first small encode")
+	heavy := acquire(t, s, large, 4)
+	notGranted(t, heavy, "the large encode")
+
+	// Small encodes keep coming while the large one waits, from the same job
+	// and from another one. They would fit, but must not go ahead of it.
+	var stream []<-chan func()
+	for range 2 {
+		stream = append(stream, acquire(t, s, small, 1), acquire(t, s, other, 1))
+	}
+	for _, granted := range stream {
+		notGranted(t, granted, "a small encode queued after the large one")
+	}
+
+	release()
+	releaseHeavy := waitGranted(t, heavy, "the large encode")
+	for _, granted := range stream {
+		notGranted(t, granted, "a small encode next to the large one")
+	}
+	releaseHeavy()
+	for _, granted := range stream {
+		waitGranted(t, granted, "a small encode after the large one")()
+	}
+}
@@ -4,53 +4,62 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/ksamf/video-upscaling/backend/internal/manifest"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
 	"github.com/ksamf/video-upscaling/backend/internal/storage"
 )
 
-func TranscodeVideo(inputPath string, targetHeight, crf int, fileName string, s3 *storage.Storage, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func TranscodeVideo(ctx context.Context, inputPath string, targetHeight, crf int, fileName string, s3 storage.ObjectStore, timeout time.Duration, duration float64, progress func(float64)) (manifest.Rendition, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	tmpOut := filepath.Join(os.TempDir(), fmt.Sprintf("%s_%d.mp4", fileName, targetHeight))
+	// The outputs are written next to the input, in the directory of its job.
+	tmpOut := filepath.Join(filepath.Dir(inputPath), fmt.Sprintf("%d.mp4", targetHeight))
+	tmpHls := filepath.Join(filepath.Dir(inputPath), fmt.Sprintf("hls_%d", targetHeight))
 	defer func() {
 		_ = os.Remove(tmpOut)
+		_ = os.RemoveAll(tmpHls)
 	}()
 
 	args := []string{
+		"-y",
 		"-i", inputPath,
 		"-map", "0:v:0",
 		"-c:v", "libx264",
+		"-profile:v", "high",
+		"-pix_fmt", "yuv420p",
 		"-crf", fmt.Sprintf("%d", crf),
 		"-vf", fmt.Sprintf("scale=-2:%d", targetHeight),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", manifest.SegmentDuration),
+		"-sc_threshold", "0",
 		"-map", "0:a?",
 		"-c:a", "aac",
 		"-b:a", "128k",
 		"-fflags", "+genpts",
+		"-movflags", "+faststart",
 		"-loglevel", "error",
 		tmpOut,
 	}
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stderr = os.Stderr
+	if err := runFFmpeg(ctx, args, duration, progress); err != nil {
+		return manifest.Rendition{}, retry.Permanent(fmt.Errorf("ffmpeg transcode failed: %w", err))
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg transcode failed: %w", err)
+	if err := putFile(ctx, s3, RenditionKey(fileName, targetHeight), tmpOut, storage.VideoObject(fileName, strconv.Itoa(targetHeight))); err != nil {
+		return manifest.Rendition{}, fmt.Errorf("s3 upload failed: %w", err)
 	}
 
-	outFile, err := os.Open(tmpOut)
+	rendition, err := PackageHLS(ctx, tmpOut, tmpHls, targetHeight)
 	if err != nil {
-		return fmt.Errorf("failed to open transcoded file: %w", err)
+		return manifest.Rendition{}, err
 	}
-	defer outFile.Close()
-
-	key := fmt.Sprintf("%s/%d.mp4", fileName, targetHeight)
-	if err := s3.PutObject(key, outFile); err != nil {
-		return fmt.Errorf("s3 upload failed: %w", err)
+	if err := UploadDir(ctx, tmpHls, HlsPrefix(fileName)+"/"+rendition.ID, s3, storage.VideoObject(fileName, rendition.ID)); err != nil {
+		return manifest.Rendition{}, fmt.Errorf("s3 hls upload failed: %w", err)
 	}
 
-	return nil
+	return rendition, nil
 }
@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SniffSize = 4096
+
+type Container struct {
+	Name string
+	Ext  string
+}
+
+type ValidationLimits struct {
+	MaxDuration float64
+	MaxWidth    int
+	MaxHeight   int
+	MaxStreams  int
+}
+
+type Rejection struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Reasons []Rejection `json:"reasons"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Reasons))
+	for _, r := range e.Reasons {
+		messages = append(messages, r.Message)
+	}
+	return "video rejected: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) reject(field, code, format string, args ...any) {
+	e.Reasons = append(e.Reasons, Rejection{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+type ProbeResult struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Size       string            `json:"size"`
+		BitRate    string            `json:"bit_rate"`
+		NbStreams  int               `json:"nb_streams"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []ProbeStream `json:"streams"`
+}
+
+type ProbeStream struct {
+	Index          int               `json:"index"`
+	CodecType      string            `json:"codec_type"`
+	CodecName      string            `json:"codec_name"`
+	Profile        string            `json:"profile"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	PixFmt         string            `json:"pix_fmt"`
+	ColorPrimaries string            `json:"color_primaries"`
+	ColorTransfer  string            `json:"color_transfer"`
+	ColorSpace     string            `json:"color_space"`
+	AvgFrameRate   string            `json:"avg_frame_rate"`
+	RFrameRate     string            `json:"r_frame_rate"`
+	BitRate        string            `json:"bit_rate"`
+	SampleRate     string            `json:"sample_rate"`
+	Channels       int               `json:"channels"`
+	ChannelLayout  string            `json:"channel_layout"`
+	Tags           map[string]string `json:"tags"`
+	SideDataList   []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+var allowedFormats = []string{"mov", "mp4", "matroska", "webm", "avi", "mpegts"}
+
+var allowedVideoCodecs = []string{"h264", "hevc", "vp8", "vp9", "av1", "mpeg4", "mpeg2video", "mpeg1video", "prores", "mjpeg"}
+
+var allowedAudioCodecs = []string{"aac", "mp3", "mp2", "opus", "vorbis", "ac3", "eac3", "flac", "alac"}
+
+// SniffContainer identifies the container from the first bytes of a file.
+func SniffContainer(header []byte) (Container, bool) {
+	switch {
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		if string(header[8:12]) == "qt  " {
+			return Container{Name: "mov", Ext: ".mov"}, true
+		}
+		return Container{Name: "mp4", Ext: ".mp4"}, true
+	case len(header) >= 8 && slices.Contains([]string{"moov", "mdat", "wide", "free"}, string(header[4:8])):
+		return Container{Name: "mov", Ext: ".mov"}, true
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(header[:min(len(header), 64)], []byte("webm")) {
+			return Container{Name: "webm", Ext: ".webm"}, true
+		}
+		return Container{Name: "matroska", Ext: ".mkv"}, true
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+		return Container{Name: "avi", Ext: ".avi"}, true
+	case isTransportStream(header, 188, 0) || isTransportStream(header, 192, 4):
+		return Container{Name: "mpegts", Ext: ".ts"}, true
+	}
+	return Container{}, false
+}
+
+func isTransportStream(header []byte, packetSize, offset int) bool {
+	if len(header) < offset+2*packetSize+1 {
+		return false
+	}
+	for i := offset; i < len(header); i += packetSize {
+		if header[i] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrInvalidMedia is returned by Probe when ffprobe read the input and
+// rejected it, as opposed to failing to run or to read it.
+var ErrInvalidMedia = errors.New("invalid media")
+
+// probeTimeout bounds a single ffprobe run.
+var probeTimeout = 30 * time.Second
+
+// invalidDataMessages are what ffprobe reports for input that isn't media it
+// can demux.
+var invalidDataMessages = []string{
+	"Invalid data found when processing input",
+	"moov atom not found",
+	"EBML header parsing failed",
+}
+
+// Probe runs ffprobe on input. Only input ffprobe rejected is reported as
+// ErrInvalidMedia; a timeout, a cancelled ctx, a missing ffprobe or an input
+// that couldn't be read are returned as they are, so the caller can retry.
+func Probe(ctx context.Context, input string) (*ProbeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-loglevel", "error", "-print_format", "json", "-show_format", "-show_streams", input)
+	output, err := cmd.Output()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", ctxErr)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr := strings.TrimSpace(string(exitErr.Stderr))
+			if slices.ContainsFunc(invalidDataMessages, func(m string) bool { return strings.Contains(stderr, m) }) {
+				return nil, fmt.Errorf("ffprobe failed: %w: %s", ErrInvalidMedia, stderr)
+			}
+			return nil, fmt.Errorf("ffprobe failed: %w: %s", err, stderr)
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+	return &result, nil
+}
+
+// ValidateMedia checks the magic bytes in header and a full ffprobe of input
+// against limits. A *ValidationError lists every reason the file is rejected;
+// any other error means the file couldn't be checked, and says nothing about
+// it.
+func ValidateMedia(ctx context.Context, input string, header []byte, limits ValidationLimits) (Container, error) {
+	verr := &ValidationError{}
+
+	container, ok := SniffContainer(header)
+	if !ok {
+		verr.reject("container", "unknown_format", "file is not a supported video container")
+		return Container{}, verr
+	}
+
+	probe, err := Probe(ctx, input)
+	if errors.Is(err, ErrInvalidMedia) {
+		verr.reject("file", "unreadable", "file could not be read as %s", container.Name)
+		return Container{}, verr
+	}
+	if err != nil {
+		return Container{}, err
+	}
+
+	formats := strings.Split(probe.Format.FormatName, ",")
+	if !slices.ContainsFunc(formats, func(f string) bool { return slices.Contains(allowedFormats, f) }) {
+		verr.reject("container", "unsupported_format", "container %s is not supported", probe.Format.FormatName)
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	switch {
+	case err != nil || duration <= 0:
+		verr.reject("duration", "invalid_duration", "duration could not be determined")
+	case limits.MaxDuration > 0 && duration > limits.MaxDuration:
+		verr.reject("duration", "too_long", "duration %.0fs exceeds the limit of %.0fs", duration, limits.MaxDuration)
+	}
+
+	if limits.MaxStreams > 0 && len(probe.Streams) > limits.MaxStreams {
+		verr.reject("streams", "too_many_streams", "file has %d streams, at most %d are allowed", len(probe.Streams), limits.MaxStreams)
+	}
+
+	videoStreams := 0
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if s.Disposition.AttachedPic == 1 {
+				continue
+			}
+			videoStreams++
+			if !slices.Contains(allowedVideoCodecs, s.CodecName) {
+				verr.reject("video_codec", "unsupported_codec", "video codec %s in stream %d is not supported", s.CodecName, s.Index)
+			}
+			if (limits.MaxWidth > 0 && s.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && s.Height > limits.MaxHeight) {
+				verr.reject("resolution", "too_large", "resolution %dx%d exceeds the limit of %dx%d", s.Width, s.Height, limits.MaxWidth, limits.MaxHeight)
+			}
+		case "audio":
+			if !slices.Contains(allowedAudioCodecs, s.CodecName) && !strings.HasPrefix(s.CodecName, "pcm_") {
+				verr.reject("audio_codec", "unsupported_codec", "audio codec %s in stream %d is not supported", s.CodecName, s.Index)
+			}
+		}
+	}
+	if videoStreams == 0 {
+		verr.reject("streams", "no_video", "file has no video stream")
+	}
+
+	if len(verr.Reasons) > 0 {
+		return Container{}, verr
+	}
+	return container, nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp4Header is enough of an mp4 for SniffContainer.
+var mp4Header = []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00")
+
+const validProbe = `{
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.5"},
+	"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1280, "height": 720},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac"}
+	]
+}`
+
+// fakeFFprobe puts an ffprobe running script first in PATH.
+func fakeFFprobe(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestValidateMediaProbeFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		valid    bool
+		rejected bool
+		wantErr  error
+	}{
+		{name: "valid", script: "cat <<'EOF'\n" + validProbe + "\nEOF", valid: true},
+		{name: "invalid data", script: "echo 'input: Invalid data found when processing input' >&2; exit 1", rejected: true},
+		{name: "moov atom not found", script: "echo '[mov,mp4] moov atom not found' >&2; exit 1", rejected: true},
+		{name: "expired link", script: "echo 'Server returned 403 Forbidden (access denied)' >&2; exit 1"},
+		{name: "connection refused", script: "echo 'Connection refused' >&2; exit 1"},
+		{name: "timeout", script: "exec sleep 10", wantErr: context.DeadlineExceeded},
+	}
+	probeTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { probeTimeout = 30 * time.Second })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFFprobe(t, tt.script)
+			container, err := ValidateMedia(context.Background(), "input", mp4Header, ValidationLimits{})
+			var verr *ValidationError
+			if errors.As(err, &verr) != tt.rejected {
+				t.Fatalf("ValidateMedia() error = %v, rejected = %v, want %v", err, !tt.rejected, tt.rejected)
+			}
+			if (err == nil) != tt.valid {
+				t.Fatalf("ValidateMedia() error = %v, want valid %v", err, tt.valid)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateMedia() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.valid && container.Name != "mp4" {
+				t.Errorf("ValidateMedia() = %+v, want mp4", container)
+			}
+		})
+	}
+}
+
+func TestValidateMediaCancelled(t *testing.T) {
+	fakeFFprobe(t, "exec sleep 10")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ValidateMedia(ctx, "input", mp4Header, ValidationLimits{})
+	var verr *ValidationError
+	if errors.As(err, &verr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("ValidateMedia() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestValidateMediaWithoutFFprobe(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := ValidateMedia(context.Background(), "input", mp4Header, ValidationLimits{})
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("ValidateMedia() error = %v, want %v", err, exec.ErrNotFound)
+	}
+}
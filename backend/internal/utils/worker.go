@@ -3,40 +3,263 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ksamf/video-upscaling/backend/internal/database"
 	broker "github.com/ksamf/video-upscaling/backend/internal/kafka"
+	cache "github.com/ksamf/video-upscaling/backend/internal/redis"
+	"github.com/ksamf/video-upscaling/backend/internal/retry"
 	"github.com/ksamf/video-upscaling/backend/internal/storage"
-	"github.com/segmentio/kafka-go"
+	"github.com/ksamf/video-upscaling/backend/pkg/events"
+	"github.com/redis/go-redis/v9"
 )
 
+// handoffRetry keeps retrying a requeue, dead-letter or commit until it goes
+// through: the offset must not be committed before the message is safe
+// somewhere else, and the worker can't move on without committing it.
+var handoffRetry = retry.Policy{BaseDelay: time.Second, MaxDelay: time.Minute}
+
+var errShuttingDown = errors.New("worker is shutting down")
+
+// WorkerOptions tunes how much work a worker takes on and how it retries and
+// shuts down.
+type WorkerOptions struct {
+	JobRetry retry.Policy
+	// Grace is how long running jobs may continue after shutdown starts.
+	Grace time.Duration
+	// MaxJobs is the number of jobs processed at the same time.
+	MaxJobs int
+	// FFmpegCapacity bounds the ffmpeg work of all jobs together, see
+	// EncodeScheduler. Zero means one unit per CPU.
+	FFmpegCapacity int
+}
+
+type videoWorker struct {
+	broker   broker.Broker
+	models   database.Models
+	s3       storage.Store
+	rdb      *redis.Client
+	outbox   *OutboxRelay
+	deleter  *Deleter
+	jobRetry retry.Policy
+	running  *runningJobs
+	encoders *EncodeScheduler
+	// stopping is closed when shutdown starts; the job context is only
+	// cancelled once the grace period runs out.
+	stopping <-chan struct{}
+}
+
+// StartVideoWorker consumes video jobs until ctx is cancelled, running up to
+// opts.MaxJobs of them at once and taking them from the priority topics by
+// weight. A message is acknowledged only after its job has either succeeded,
+// been requeued with the next attempt number, or been moved to the
+// dead-letter topic once opts.JobRetry.Attempts is exhausted. On shutdown
+// running jobs get opts.Grace to finish; the ones that don't are interrupted
+// and nacked so another worker picks them up again. Events about the videos
+// are recorded through outbox.
 func StartVideoWorker(
-	reader *kafka.Reader,
-	db database.VideoModel,
-	s3 *storage.Storage,
-) {
+	ctx context.Context,
+	b broker.Broker,
+	models database.Models,
+	s3 storage.Store,
+	rdb *redis.Client,
+	outbox *OutboxRelay,
+	opts WorkerOptions,
+) error {
+	consumers, err := broker.SubscribeJobs(b)
+	if err != nil {
+		return err
+	}
+
+	workCtx, abort := context.WithCancelCause(context.Background())
+	defer abort(nil)
+	go func() {
+		<-ctx.Done()
+		timer := time.NewTimer(opts.Grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Printf("Worker grace period of %s is over, interrupting running jobs", opts.Grace)
+			abort(errShuttingDown)
+		case <-workCtx.Done():
+		}
+	}()
+
+	w := &videoWorker{
+		broker:   b,
+		models:   models,
+		s3:       s3,
+		rdb:      rdb,
+		outbox:   outbox,
+		deleter:  NewDeleter(models, s3, rdb, outbox),
+		jobRetry: opts.JobRetry,
+		running:  newRunningJobs(),
+		encoders: NewEncodeScheduler(opts.FFmpegCapacity),
+		stopping: ctx.Done(),
+	}
+	go watchCancellations(workCtx, rdb, w.running)
+
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		for _, consumer := range consumers {
+			consumer.Close()
+		}
+		log.Println("Worker stopped")
+	}()
+	feed := newJobFeed(ctx, consumers)
+	slots := make(chan struct{}, max(opts.MaxJobs, 1))
 	for {
-		msg, err := reader.ReadMessage(context.Background())
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		d, err := feed.next(ctx)
 		if err != nil {
-			log.Printf("Kafka read error: %v", err)
-			time.Sleep(time.Second)
-			continue
+			return nil
 		}
 
-		var job broker.VideoJob
-		if err := json.Unmarshal(msg.Value, &job); err != nil {
-			log.Printf("Invalid job: %v", err)
-			continue
+		wg.Go(func() {
+			defer func() { <-slots }()
+			w.settle(workCtx, d, w.handle(workCtx, d.Message))
+		})
+	}
+}
+
+func (w *videoWorker) settle(ctx context.Context, d *broker.Delivery, ack bool) {
+	if !ack {
+		log.Printf("Message on %s left unacknowledged", d.Topic)
+		if err := d.Nack(ctx); err != nil {
+			log.Printf("Broker nack error: %v", err)
 		}
+		return
+	}
+	err := retry.Do(ctx, handoffRetry, func() error {
+		return d.Ack(ctx)
+	})
+	if err != nil {
+		log.Printf("Broker ack error: %v", err)
+	}
+}
+
+// handle processes one message and reports whether it may be acknowledged.
+func (w *videoWorker) handle(ctx context.Context, msg broker.Message) bool {
+	var job broker.VideoJob
+	if err := json.Unmarshal(msg.Value, &job); err != nil {
+		log.Printf("Invalid job: %v", err)
+		w.deadLetter(ctx, msg, fmt.Errorf("invalid job: %w", err))
+		return true
+	}
 
-		log.Printf("Processing job %s (%s)", job.VideoID, job.FileName)
+	attempt := broker.Attempt(msg)
+	log.Printf("Processing job %s (%s) from %s, attempt %d", job.VideoID, job.FileName, msg.Topic, attempt)
 
-		if err := processVideoJob(job, db, s3); err != nil {
-			log.Printf("Job %s failed: %v", job.VideoID, err)
-		} else {
-			log.Printf("Job %s completed successfully", job.VideoID)
+	// The job finished but the message wasn't acknowledged before a crash.
+	if current, err := w.models.Jobs.GetByID(job.JobID); err != nil {
+		log.Printf("Job %s: %v", job.JobID, err)
+	} else if current != nil && current.Status == database.JobDone {
+		log.Printf("Job %s is already done", job.VideoID)
+		return true
+	}
+
+	tracker := newProgressTracker(w.rdb, w.models.Jobs, job.VideoID, job.JobID, attempt)
+	if cancelled, err := cache.IsCancelled(ctx, w.rdb, job.JobID); err != nil {
+		log.Printf("Job %s: %v", job.JobID, err)
+	} else if cancelled {
+		log.Printf("Job %s was cancelled before it started", job.VideoID)
+		w.finishCancelled(job, tracker)
+		return true
+	}
+
+	jobCtx, done := w.running.start(ctx, job.JobID)
+	err := processVideoJob(jobCtx, job, w.models, w.s3, w.encoders, w.outbox, tracker)
+	cause := context.Cause(jobCtx)
+	done()
+	switch {
+	case errors.Is(cause, errJobCancelled):
+		log.Printf("Job %s cancelled", job.VideoID)
+		w.finishCancelled(job, tracker)
+		return true
+	case errors.Is(cause, errShuttingDown):
+		log.Printf("Job %s interrupted by shutdown", job.VideoID)
+		tracker.setStatus(database.JobQueued)
+		return false
+	case errors.Is(err, database.ErrVideoNotFound):
+		log.Printf("Job %s dropped, its video was deleted", job.VideoID)
+		return true
+	}
+	if err == nil {
+		log.Printf("Job %s completed successfully", job.VideoID)
+		tracker.setStatus(database.JobDone)
+		w.setVideoStatus(job.VideoID, database.VideoReady)
+		return true
+	}
+
+	if retry.IsPermanent(err) || (w.jobRetry.Attempts > 0 && attempt >= w.jobRetry.Attempts) {
+		log.Printf("Job %s failed on attempt %d, moving to DLQ: %v", job.VideoID, attempt, err)
+		tracker.fail(err)
+		// A reprocessed video still has what its first job produced.
+		if job.SourceKey == "" {
+			w.setVideoStatus(job.VideoID, database.VideoFailed)
 		}
+		emitEvent(w.outbox, events.TypeProcessingFailed, job.VideoID, events.ProcessingFailed{
+			JobID:   job.JobID,
+			Attempt: attempt,
+			Error:   err.Error(),
+		})
+		w.deadLetter(ctx, msg, err)
+		return true
+	}
+
+	delay := w.jobRetry.Backoff(attempt)
+	log.Printf("Job %s failed on attempt %d, retrying in %s: %v", job.VideoID, attempt, delay, err)
+	tracker.retry(err, attempt+1)
+	// Requeue right away when shutting down rather than holding up the exit.
+	select {
+	case <-time.After(delay):
+	case <-w.stopping:
+	}
+	err = retry.Do(ctx, handoffRetry, func() error {
+		return broker.Requeue(ctx, w.broker, msg, attempt+1)
+	})
+	if err != nil {
+		log.Printf("Job %s: %v", job.VideoID, err)
+		return false
+	}
+	return true
+}
+
+func (w *videoWorker) finishCancelled(job broker.VideoJob, tracker *progressTracker) {
+	if err := cleanupCancelled(w.models, w.deleter, w.s3, job); err != nil {
+		log.Printf("Job %s: cleanup failed: %v", job.JobID, err)
+	}
+	tracker.cancelled()
+}
+
+func (w *videoWorker) deadLetter(ctx context.Context, msg broker.Message, cause error) {
+	err := retry.Do(ctx, handoffRetry, func() error {
+		return broker.DeadLetter(ctx, w.broker, msg, cause)
+	})
+	if err != nil {
+		log.Printf("Kafka DLQ error: %v", err)
+	}
+}
+
+func (w *videoWorker) setVideoStatus(id uuid.UUID, status string) {
+	if err := w.models.Videos.SetStatus(id, status); err != nil {
+		log.Printf("Video %s: failed to set status %s: %v", id, status, err)
+	}
+}
+
+func setJobStatus(jobs database.JobModel, id uuid.UUID, status string) {
+	if err := jobs.SetStatus(id, status); err != nil {
+		log.Printf("Job %s: failed to set status %s: %v", id, status, err)
 	}
 }
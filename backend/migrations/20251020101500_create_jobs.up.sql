@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    job_id UUID PRIMARY KEY,
+    video_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    error TEXT,
+    queued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    downloading_at TIMESTAMP,
+    transcoding_at TIMESTAMP,
+    subtitling_at TIMESTAMP,
+    upscaling_at TIMESTAMP,
+    done_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_video_id ON jobs (video_id);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS video_metadata (
+    video_id UUID PRIMARY KEY,
+    format_name TEXT NOT NULL DEFAULT '',
+    duration DOUBLE PRECISION NOT NULL DEFAULT 0,
+    size BIGINT NOT NULL DEFAULT 0,
+    bit_rate BIGINT NOT NULL DEFAULT 0,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    video_codec VARCHAR(32) NOT NULL DEFAULT '',
+    video_profile VARCHAR(64) NOT NULL DEFAULT '',
+    video_bit_rate BIGINT NOT NULL DEFAULT 0,
+    frame_rate DOUBLE PRECISION NOT NULL DEFAULT 0,
+    pixel_format VARCHAR(32) NOT NULL DEFAULT '',
+    color_primaries VARCHAR(32) NOT NULL DEFAULT '',
+    color_transfer VARCHAR(32) NOT NULL DEFAULT '',
+    color_space VARCHAR(32) NOT NULL DEFAULT '',
+    rotation INTEGER NOT NULL DEFAULT 0,
+    audio_codec VARCHAR(32) NOT NULL DEFAULT '',
+    audio_channels INTEGER NOT NULL DEFAULT 0,
+    audio_channel_layout VARCHAR(32) NOT NULL DEFAULT '',
+    audio_sample_rate INTEGER NOT NULL DEFAULT 0,
+    audio_bit_rate BIGINT NOT NULL DEFAULT 0,
+    tags JSONB NOT NULL DEFAULT '{}',
+    creation_time TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_video FOREIGN KEY (video_id) REFERENCES videos (video_id) ON DELETE CASCADE
+);
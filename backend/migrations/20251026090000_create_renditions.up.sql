@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS renditions (
+    video_id UUID NOT NULL,
+    height INTEGER NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    codec VARCHAR(32) NOT NULL DEFAULT '',
+    bitrate BIGINT NOT NULL DEFAULT 0,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    s3_key TEXT NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (video_id, height)
+);
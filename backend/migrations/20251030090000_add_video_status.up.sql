@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'ready';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS job_steps (
+    job_id UUID NOT NULL,
+    step VARCHAR(32) NOT NULL,
+    data JSONB,
+    done_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (job_id, step)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS video_deletions (
+    video_id UUID PRIMARY KEY,
+    step VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    done_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_deletions_pending ON video_deletions (updated_at) WHERE done_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS visibility VARCHAR(10) NOT NULL DEFAULT 'public';
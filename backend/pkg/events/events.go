@@ -0,0 +1,128 @@
+// Package events defines the video lifecycle events the backend publishes to
+// the video-events topic, for services that want to react to them.
+//
+// Every message is an Event encoded as JSON. The message key is the video id,
+// so the events of one video arrive in the order they were emitted. Delivery
+// is at least once: consumers should use Event.ID to drop duplicates.
+//
+// Version is the version of the schema of Data for the event type. Fields may
+// be added to a payload without a new version; removing or changing one bumps
+// it. Consumers should skip versions they don't know.
+//
+//	var e events.Event
+//	if err := json.Unmarshal(msg.Value, &e); err != nil { ... }
+//	switch e.Type {
+//	case events.TypeRenditionReady:
+//		var r events.RenditionReady
+//		if err := e.Decode(&r); err != nil { ... }
+//	}
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Topic is the Kafka topic the events are published to.
+const Topic = "video-events"
+
+const (
+	// TypeVideoUploaded is sent once an upload is stored and its first job is
+	// queued. Data is VideoUploaded.
+	TypeVideoUploaded = "video.uploaded"
+	// TypeRenditionReady is sent for every rendition that is stored and can
+	// be played, upscaled ones included. Data is RenditionReady.
+	TypeRenditionReady = "video.rendition.ready"
+	// TypeSubtitlesReady is sent when the subtitles in the spoken language
+	// are created and again for every translation. Data is SubtitlesReady.
+	TypeSubtitlesReady = "video.subtitles.ready"
+	// TypeProcessingFailed is sent when a job has failed for good and won't
+	// be retried. Data is ProcessingFailed.
+	TypeProcessingFailed = "video.processing.failed"
+	// TypeVideoDeleted is sent when a video and everything stored for it is
+	// deleted. Data is VideoDeleted.
+	TypeVideoDeleted = "video.deleted"
+)
+
+// Versions holds the current schema version of every event type.
+var Versions = map[string]int{
+	TypeVideoUploaded:    1,
+	TypeRenditionReady:   1,
+	TypeSubtitlesReady:   1,
+	TypeProcessingFailed: 1,
+	TypeVideoDeleted:     1,
+}
+
+// Event is the envelope shared by all event types.
+type Event struct {
+	// ID is unique per event and stays the same when it is redelivered.
+	ID      uuid.UUID       `json:"id"`
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	VideoID uuid.UUID       `json:"video_id"`
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type VideoUploaded struct {
+	Name     string    `json:"name"`
+	JobID    uuid.UUID `json:"job_id"`
+	Priority string    `json:"priority"`
+	Upscale  bool      `json:"upscale"`
+}
+
+type RenditionReady struct {
+	JobID  uuid.UUID `json:"job_id"`
+	Height int       `json:"height"`
+	// Source is "original", "transcode" or "upscale".
+	Source string `json:"source"`
+	// Key is the object key of the MP4 in the bucket.
+	Key string `json:"key"`
+}
+
+type SubtitlesReady struct {
+	// Language is the code of the subtitle language, such as "en".
+	Language string `json:"language"`
+	// Key is the object key of the WebVTT file in the bucket.
+	Key string `json:"key"`
+	// Translated is false for the subtitles in the spoken language.
+	Translated bool `json:"translated"`
+}
+
+type ProcessingFailed struct {
+	JobID   uuid.UUID `json:"job_id"`
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+}
+
+type VideoDeleted struct{}
+
+// New returns an event of eventType about videoID with data as its payload,
+// stamped with the current version of eventType.
+func New(eventType string, videoID uuid.UUID, data any) (Event, error) {
+	version, ok := Versions[eventType]
+	if !ok {
+		return Event{}, fmt.Errorf("unknown event type %q", eventType)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal %s: %w", eventType, err)
+	}
+	return Event{
+		ID:      uuid.New(),
+		Type:    eventType,
+		Version: version,
+		VideoID: videoID,
+		Time:    time.Now().UTC(),
+		Data:    raw,
+	}, nil
+}
+
+// Decode unmarshals the payload of e into v, which should be the type
+// documented for e.Type.
+func (e Event) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
@@ -1,5 +1,7 @@
 from contextlib import asynccontextmanager
+import hashlib
 import logging
+import mimetypes
 import os
 from aiobotocore.session import get_session
 
@@ -8,6 +10,29 @@ from botocore.exceptions import ClientError
 
 logger = logging.getLogger(__name__)
 
+# Kept in line with the content types the backend sets on its own objects.
+CONTENT_TYPES = {
+    ".mp4": "video/mp4",
+    ".vtt": "text/vtt",
+    ".mp3": "audio/mpeg",
+    ".m3u8": "application/vnd.apple.mpegurl",
+}
+CACHE_CONTROL = "max-age=86400"
+
+
+def content_type(file_path: str) -> str:
+    ext = os.path.splitext(file_path)[1].lower()
+    guessed, _ = mimetypes.guess_type(file_path)
+    return CONTENT_TYPES.get(ext) or guessed or "application/octet-stream"
+
+
+def file_checksum(file_path: str) -> str:
+    digest = hashlib.sha256()
+    with open(file_path, "rb") as f:
+        for chunk in iter(lambda: f.read(1 << 20), b""):
+            digest.update(chunk)
+    return digest.hexdigest()
+
 
 class S3Client:
     def __init__(
@@ -36,19 +61,29 @@ class S3Client:
         file_path: str,
     ):
         """
-        Uploads a file to the specified S3 bucket and folder.
+        Uploads a file to the specified S3 bucket and folder, with its content
+        type, size, caching policy and checksum.
 
         Args:
             folder_name (str): Folder in the S3 bucket where the file will be uploaded.
             file_path (str): Path to the local file to be uploaded.
         """
+        name = os.path.basename(file_path)
+        metadata = {"video-id": folder_name, "sha256": file_checksum(file_path)}
+        stem = os.path.splitext(name)[0]
+        if stem.isdigit():
+            metadata["rendition"] = stem
         try:
             async with self.get_client() as client:
                 with open(file_path, "rb") as f:
                     await client.put_object(
                         Bucket=self.bucket_name,
-                        Key=f"{folder_name}/{os.path.basename(file_path)}",
+                        Key=f"{folder_name}/{name}",
                         Body=f,
+                        ContentLength=os.path.getsize(file_path),
+                        ContentType=content_type(file_path),
+                        CacheControl=CACHE_CONTROL,
+                        Metadata=metadata,
                     )
             logger.info(f"File '{file_path}' uploaded successfully.")
         except ClientError as e: